@@ -2,10 +2,9 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -13,23 +12,34 @@ import (
 	_ "embed"
 
 	"github.com/jessevdk/go-flags"
+	"github.com/slack-go/slack"
 
+	"github.com/chuhlomin/slack-exporter/pkg/httpclient"
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/reader"
 	"github.com/chuhlomin/slack-exporter/pkg/structs"
 )
 
 type config struct {
-	Input  string `long:"input" description:"Input JSON file" required:"true"`
-	Output string `long:"output" description:"Output directory file" required:"true"`
+	Input      string `long:"input" description:"Input JSON file, directory or bundle (.zip or .tar.gz)" required:"true"`
+	Output     string `long:"output" description:"Output directory file" required:"true"`
+	Passphrase string `env:"PASSPHRASE" long:"passphrase" description:"Passphrase to decrypt encrypted input files"`
+	KeyFile    string `env:"KEY_FILE" long:"key-file" description:"Key file to decrypt encrypted input files"`
+
+	Logging logging.Options    `group:"Logging Options"`
+	HTTP    httpclient.Options `group:"HTTP Options"`
 }
 
 var (
 	cfg            config
 	errBadResponse = fmt.Errorf("bad response")
+	errNoChannels  = fmt.Errorf("no exported channels found")
 )
 
 func main() {
 	if err := run(); err != nil {
-		log.Fatalf("Error: %v", err)
+		slog.Error("Could not download avatars", logging.Err(err))
+		os.Exit(1)
 	}
 }
 
@@ -38,19 +48,29 @@ func run() error {
 		return fmt.Errorf("could not parse flags: %w", err)
 	}
 
-	var data structs.Data
-	content, err := os.ReadFile(cfg.Input)
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		return fmt.Errorf("could not set up logging: %w", err)
+	}
+
+	secret, err := structs.LoadSecret(cfg.Passphrase, cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load encryption secret: %w", err)
+	}
+
+	client, err := httpclient.New(cfg.HTTP)
 	if err != nil {
-		return fmt.Errorf("could not read file: %w", err)
+		return fmt.Errorf("could not create HTTP client: %w", err)
 	}
 
-	if err := json.Unmarshal(content, &data); err != nil {
-		return fmt.Errorf("could not unmarshal messages: %w", err)
+	users, err := readUsers(cfg.Input, secret)
+	if err != nil {
+		return err
 	}
 
-	for _, user := range data.Users {
+	for _, user := range users {
 		if user.Profile.Image512 != "" {
-			err := downloadFile(user.ID, user.Profile.Image512, cfg.Output)
+			slog.Debug("Downloading avatar", logging.KeyUser, user.ID)
+			err := downloadFile(client, user.ID, user.Profile.Image512, cfg.Output)
 			if err != nil {
 				return fmt.Errorf("could not download file: %w", err)
 			}
@@ -60,13 +80,42 @@ func run() error {
 	return nil
 }
 
-func downloadFile(id, fileURL, output string) error {
+// readUsers collects users from a single exported channel,
+// or from all channels in a directory or bundle.
+func readUsers(input string, secret *structs.Secret) (map[string]*slack.User, error) {
+	export, err := reader.Open(input, reader.WithSecret(secret))
+	if err != nil {
+		return nil, err
+	}
+	defer export.Close()
+
+	users := map[string]*slack.User{}
+	count := 0
+
+	channels := export.Channels()
+	for channels.Next() {
+		for id, user := range channels.Channel().Data.Users {
+			users[id] = user
+		}
+		count++
+	}
+	if err := channels.Err(); err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		return nil, fmt.Errorf("%w: %s", errNoChannels, input)
+	}
+
+	return users, nil
+}
+
+func downloadFile(client *http.Client, id, fileURL, output string) error {
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, fileURL, http.NoBody)
 	if err != nil {
 		return err
 	}
 
-	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
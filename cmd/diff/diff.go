@@ -0,0 +1,314 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"sort"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// Channel states when a channel is only in one of the exports.
+const (
+	channelAdded   = "added"
+	channelRemoved = "removed"
+)
+
+// channelDiff lists what changed in a channel between two exports.
+type channelDiff struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status,omitempty"`
+
+	Topic          *change  `json:"topic,omitempty"`
+	Purpose        *change  `json:"purpose,omitempty"`
+	MembersAdded   []string `json:"members_added,omitempty"`
+	MembersRemoved []string `json:"members_removed,omitempty"`
+
+	MessagesAdded   []message  `json:"messages_added,omitempty"`
+	MessagesRemoved []message  `json:"messages_removed,omitempty"`
+	MessagesEdited  []edit     `json:"messages_edited,omitempty"`
+	RepliesAdded    []message  `json:"replies_added,omitempty"`
+	RepliesRemoved  []message  `json:"replies_removed,omitempty"`
+	RepliesEdited   []edit     `json:"replies_edited,omitempty"`
+	Reactions       []reaction `json:"reactions,omitempty"`
+	FilesAdded      []file     `json:"files_added,omitempty"`
+
+	// users name the users in the text output
+	users map[string]*slack.User
+}
+
+type change struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// message is a message or reply; Thread is set for replies.
+type message struct {
+	Timestamp string `json:"ts"`
+	Thread    string `json:"thread_ts,omitempty"`
+	User      string `json:"user,omitempty"`
+	Text      string `json:"text"`
+}
+
+type edit struct {
+	message
+	Before string `json:"before"`
+}
+
+type reaction struct {
+	Timestamp    string   `json:"ts"`
+	Name         string   `json:"name"`
+	From         int      `json:"from"`
+	To           int      `json:"to"`
+	UsersAdded   []string `json:"users_added,omitempty"`
+	UsersRemoved []string `json:"users_removed,omitempty"`
+}
+
+type file struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Timestamp string `json:"ts"`
+}
+
+// empty reports whether nothing changed.
+func (d *channelDiff) empty() bool {
+	return d.Status == "" && d.Topic == nil && d.Purpose == nil &&
+		len(d.MembersAdded)+len(d.MembersRemoved) == 0 &&
+		len(d.MessagesAdded)+len(d.MessagesRemoved)+len(d.MessagesEdited) == 0 &&
+		len(d.RepliesAdded)+len(d.RepliesRemoved)+len(d.RepliesEdited) == 0 &&
+		len(d.Reactions)+len(d.FilesAdded) == 0
+}
+
+// diffChannel compares two exports of the same channel; either may be nil
+// if the channel is only in one of them. Messages marked deleted in the
+// history of an incremental export count as removed.
+func diffChannel(before, after *structs.Data) *channelDiff {
+	d := &channelDiff{}
+
+	switch {
+	case before == nil:
+		d.Status = channelAdded
+		before = &structs.Data{Channel: after.Channel}
+	case after == nil:
+		d.Status = channelRemoved
+		after = &structs.Data{Channel: before.Channel, Users: before.Users}
+	}
+
+	d.ID = after.Channel.ID
+	d.Name = structs.ChannelName(after.Channel, after.Users)
+	d.users = after.Users
+
+	if before.Channel.Topic.Value != after.Channel.Topic.Value {
+		d.Topic = &change{From: before.Channel.Topic.Value, To: after.Channel.Topic.Value}
+	}
+	if before.Channel.Purpose.Value != after.Channel.Purpose.Value {
+		d.Purpose = &change{From: before.Channel.Purpose.Value, To: after.Channel.Purpose.Value}
+	}
+
+	d.diffMembers(before, after)
+
+	oldMessages := messages(before)
+	newMessages := messages(after)
+
+	for ts, n := range newMessages {
+		o, ok := oldMessages[ts]
+		if !ok || isDeleted(before, ts) {
+			if !isDeleted(after, ts) {
+				d.added(n)
+			}
+			continue
+		}
+
+		if isDeleted(after, ts) {
+			d.removed(o)
+			continue
+		}
+
+		if structs.IsEdit(o.Msg, n.Msg) {
+			d.edited(o, n)
+		}
+		d.diffReactions(o, n)
+	}
+
+	for ts, o := range oldMessages {
+		if _, ok := newMessages[ts]; !ok && !isDeleted(before, ts) {
+			d.removed(o)
+		}
+	}
+
+	d.diffFiles(oldMessages, newMessages)
+	d.sort()
+
+	return d
+}
+
+// diffMembers compares the member lists, where both exports have one,
+// and adds the people who joined or left in new messages.
+func (d *channelDiff) diffMembers(before, after *structs.Data) {
+	if len(before.Channel.Members) > 0 && len(after.Channel.Members) > 0 {
+		d.MembersAdded = subtract(after.Channel.Members, before.Channel.Members)
+		d.MembersRemoved = subtract(before.Channel.Members, after.Channel.Members)
+		return
+	}
+
+	oldMessages := messages(before)
+	for ts, m := range messages(after) {
+		if _, ok := oldMessages[ts]; ok {
+			continue
+		}
+
+		switch m.SubType {
+		case slack.MsgSubTypeChannelJoin, slack.MsgSubTypeGroupJoin:
+			d.MembersAdded = append(d.MembersAdded, m.User)
+		case slack.MsgSubTypeChannelLeave, slack.MsgSubTypeGroupLeave:
+			d.MembersRemoved = append(d.MembersRemoved, m.User)
+		}
+	}
+}
+
+// diffReactions compares the reactions of a message in both exports.
+func (d *channelDiff) diffReactions(before, after slack.Message) {
+	names := map[string]bool{}
+	for _, r := range before.Reactions {
+		names[r.Name] = true
+	}
+	for _, r := range after.Reactions {
+		names[r.Name] = true
+	}
+
+	for name := range names {
+		o := findReaction(before.Reactions, name)
+		n := findReaction(after.Reactions, name)
+		if o.Count == n.Count && slices.Equal(o.Users, n.Users) {
+			continue
+		}
+
+		d.Reactions = append(d.Reactions, reaction{
+			Timestamp:    after.Timestamp,
+			Name:         name,
+			From:         o.Count,
+			To:           n.Count,
+			UsersAdded:   subtract(n.Users, o.Users),
+			UsersRemoved: subtract(o.Users, n.Users),
+		})
+	}
+}
+
+// diffFiles lists the files attached to messages that only the new export has.
+func (d *channelDiff) diffFiles(before, after map[string]slack.Message) {
+	seen := map[string]bool{}
+	for _, m := range before {
+		for _, f := range m.Files {
+			seen[f.ID] = true
+		}
+	}
+
+	for _, m := range after {
+		for _, f := range m.Files {
+			if !seen[f.ID] {
+				seen[f.ID] = true
+				d.FilesAdded = append(d.FilesAdded, file{ID: f.ID, Name: cmp.Or(f.Name, f.Title), Timestamp: m.Timestamp})
+			}
+		}
+	}
+}
+
+func (d *channelDiff) added(m slack.Message) {
+	if isReply(m) {
+		d.RepliesAdded = append(d.RepliesAdded, toMessage(m))
+		return
+	}
+	d.MessagesAdded = append(d.MessagesAdded, toMessage(m))
+}
+
+func (d *channelDiff) removed(m slack.Message) {
+	if isReply(m) {
+		d.RepliesRemoved = append(d.RepliesRemoved, toMessage(m))
+		return
+	}
+	d.MessagesRemoved = append(d.MessagesRemoved, toMessage(m))
+}
+
+func (d *channelDiff) edited(before, after slack.Message) {
+	e := edit{message: toMessage(after), Before: before.Text}
+	if isReply(after) {
+		d.RepliesEdited = append(d.RepliesEdited, e)
+		return
+	}
+	d.MessagesEdited = append(d.MessagesEdited, e)
+}
+
+// sort orders everything by time, as maps were iterated.
+func (d *channelDiff) sort() {
+	for _, list := range [][]message{d.MessagesAdded, d.MessagesRemoved, d.RepliesAdded, d.RepliesRemoved} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Timestamp < list[j].Timestamp })
+	}
+	for _, list := range [][]edit{d.MessagesEdited, d.RepliesEdited} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Timestamp < list[j].Timestamp })
+	}
+	sort.Slice(d.Reactions, func(i, j int) bool {
+		if d.Reactions[i].Timestamp != d.Reactions[j].Timestamp {
+			return d.Reactions[i].Timestamp < d.Reactions[j].Timestamp
+		}
+		return d.Reactions[i].Name < d.Reactions[j].Name
+	})
+	sort.Slice(d.FilesAdded, func(i, j int) bool { return d.FilesAdded[i].Timestamp < d.FilesAdded[j].Timestamp })
+	sort.Strings(d.MembersAdded)
+	sort.Strings(d.MembersRemoved)
+}
+
+// messages returns the messages and replies of the channel by timestamp.
+func messages(d *structs.Data) map[string]slack.Message {
+	result := map[string]slack.Message{}
+	for _, m := range d.Messages {
+		result[m.Timestamp] = m.Message
+		for _, r := range m.Replies {
+			result[r.Timestamp] = r
+		}
+	}
+
+	return result
+}
+
+func isDeleted(d *structs.Data, ts string) bool {
+	h := d.HistoryOf(ts)
+	return h != nil && h.Deleted != nil
+}
+
+func isReply(m slack.Message) bool {
+	return m.ThreadTimestamp != "" && m.ThreadTimestamp != m.Timestamp
+}
+
+func toMessage(m slack.Message) message {
+	result := message{Timestamp: m.Timestamp, User: m.User, Text: m.Text}
+	if isReply(m) {
+		result.Thread = m.ThreadTimestamp
+	}
+
+	return result
+}
+
+func findReaction(reactions []slack.ItemReaction, name string) slack.ItemReaction {
+	for _, r := range reactions {
+		if r.Name == name {
+			return r
+		}
+	}
+
+	return slack.ItemReaction{Name: name}
+}
+
+// subtract returns the items of a that are not in b.
+func subtract(a, b []string) []string {
+	var result []string
+	for _, s := range a {
+		if !slices.Contains(b, s) {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
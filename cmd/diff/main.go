@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/reader"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+type config struct {
+	From       string `long:"from" description:"Earlier export: JSON file, directory or bundle (.zip or .tar.gz)" required:"true"`
+	To         string `long:"to" description:"Later export of the same channels: JSON file, directory or bundle" required:"true"`
+	Format     string `long:"format" description:"Output format" choice:"text" choice:"json" default:"text"`
+	Timezone   string `env:"TIMEZONE" long:"timezone" description:"Time zone for message times in text output, like Europe/Berlin" default:"UTC"`
+	Passphrase string `env:"PASSPHRASE" long:"passphrase" description:"Passphrase to decrypt encrypted input files"`
+	KeyFile    string `env:"KEY_FILE" long:"key-file" description:"Key file to decrypt encrypted input files"`
+
+	Logging logging.Options `group:"Logging Options"`
+}
+
+var (
+	cfg      config
+	secret   *structs.Secret
+	location *time.Location
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("Could not compare exports", logging.Err(err))
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if _, err := flags.Parse(&cfg); err != nil {
+		return fmt.Errorf("could not parse flags: %w", err)
+	}
+
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		return fmt.Errorf("could not set up logging: %w", err)
+	}
+
+	var err error
+	location, err = time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return fmt.Errorf("could not load time zone %q: %w", cfg.Timezone, err)
+	}
+
+	secret, err = structs.LoadSecret(cfg.Passphrase, cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load encryption secret: %w", err)
+	}
+
+	before, err := readChannels(cfg.From)
+	if err != nil {
+		return err
+	}
+
+	after, err := readChannels(cfg.To)
+	if err != nil {
+		return err
+	}
+
+	// channels of both exports, by ID
+	ids := make([]string, 0, len(after))
+	for id := range after {
+		ids = append(ids, id)
+	}
+	for id := range before {
+		if _, ok := after[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	diffs := []*channelDiff{}
+	for _, id := range ids {
+		d := diffChannel(before[id], after[id])
+		if !d.empty() {
+			diffs = append(diffs, d)
+		}
+	}
+
+	if cfg.Format == formatJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			From     string         `json:"from"`
+			To       string         `json:"to"`
+			Channels []*channelDiff `json:"channels"`
+		}{cfg.From, cfg.To, diffs})
+	}
+
+	for _, d := range diffs {
+		writeText(os.Stdout, d)
+	}
+	fmt.Fprintf(os.Stdout, "%d of %d channel(s) changed\n", len(diffs), len(ids))
+
+	return nil
+}
+
+// readChannels reads the exported channels by ID.
+func readChannels(path string) (map[string]*structs.Data, error) {
+	export, err := reader.Open(path, reader.WithSecret(secret))
+	if err != nil {
+		return nil, err
+	}
+	defer export.Close()
+
+	result := map[string]*structs.Data{}
+	channels := export.Channels()
+	for channels.Next() {
+		data := channels.Channel().Data
+		result[data.Channel.ID] = data
+	}
+
+	return result, channels.Err()
+}
+
+// writeText writes the changes of a channel, one per line:
+// + added, - removed, ~ edited.
+func writeText(w io.Writer, d *channelDiff) {
+	fmt.Fprintf(w, "#%s (%s)", d.Name, d.ID)
+	if d.Status != "" {
+		fmt.Fprintf(w, " %s", d.Status)
+	}
+	fmt.Fprintln(w)
+
+	if d.Topic != nil {
+		fmt.Fprintf(w, "  ~ topic: %q -> %q\n", d.Topic.From, d.Topic.To)
+	}
+	if d.Purpose != nil {
+		fmt.Fprintf(w, "  ~ purpose: %q -> %q\n", d.Purpose.From, d.Purpose.To)
+	}
+	for _, id := range d.MembersAdded {
+		fmt.Fprintf(w, "  + member %s\n", d.user(id))
+	}
+	for _, id := range d.MembersRemoved {
+		fmt.Fprintf(w, "  - member %s\n", d.user(id))
+	}
+
+	for _, m := range d.MessagesAdded {
+		fmt.Fprintf(w, "  + %s\n", d.message(m))
+	}
+	for _, m := range d.MessagesRemoved {
+		fmt.Fprintf(w, "  - %s\n", d.message(m))
+	}
+	for _, e := range d.MessagesEdited {
+		fmt.Fprintf(w, "  ~ %s (was: %s)\n", d.message(e.message), oneLine(e.Before))
+	}
+	for _, m := range d.RepliesAdded {
+		fmt.Fprintf(w, "  + reply %s\n", d.message(m))
+	}
+	for _, m := range d.RepliesRemoved {
+		fmt.Fprintf(w, "  - reply %s\n", d.message(m))
+	}
+	for _, e := range d.RepliesEdited {
+		fmt.Fprintf(w, "  ~ reply %s (was: %s)\n", d.message(e.message), oneLine(e.Before))
+	}
+
+	for _, r := range d.Reactions {
+		var users []string
+		for _, id := range r.UsersAdded {
+			users = append(users, "+"+d.user(id))
+		}
+		for _, id := range r.UsersRemoved {
+			users = append(users, "-"+d.user(id))
+		}
+
+		fmt.Fprintf(w, "  ~ :%s: on %s: %d -> %d", r.Name, formatTime(r.Timestamp), r.From, r.To)
+		if len(users) > 0 {
+			fmt.Fprintf(w, " (%s)", strings.Join(users, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+
+	for _, f := range d.FilesAdded {
+		fmt.Fprintf(w, "  + file %s (%s) on %s\n", f.Name, f.ID, formatTime(f.Timestamp))
+	}
+
+	fmt.Fprintln(w)
+}
+
+// message formats a message as [time] @author: text,
+// with the thread for replies.
+func (d *channelDiff) message(m message) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[%s]", formatTime(m.Timestamp))
+	if m.Thread != "" {
+		fmt.Fprintf(&sb, " in thread [%s]", formatTime(m.Thread))
+	}
+	if m.User != "" {
+		fmt.Fprintf(&sb, " %s", d.user(m.User))
+	}
+	fmt.Fprintf(&sb, ": %s", oneLine(m.Text))
+
+	return sb.String()
+}
+
+// user returns the name of the user, or the ID if it wasn't exported.
+func (d *channelDiff) user(id string) string {
+	if u, ok := d.users[id]; ok {
+		return "@" + structs.UserName(u)
+	}
+
+	return "@" + id
+}
+
+func formatTime(ts string) string {
+	t, err := structs.ParseTimestamp(ts)
+	if err != nil {
+		return ts
+	}
+
+	return t.In(location).Format("2006-01-02 15:04:05")
+}
+
+// oneLine joins the lines of the text.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
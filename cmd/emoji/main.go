@@ -5,7 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -17,11 +17,19 @@ import (
 	"github.com/jessevdk/go-flags"
 	"github.com/slack-go/slack"
 	"golang.org/x/time/rate"
+
+	"github.com/chuhlomin/slack-exporter/pkg/httpclient"
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
 )
 
 type config struct {
 	Token  string `env:"API_TOKEN" long:"token" description:"Slack API token" required:"true"`
 	Output string `long:"output" description:"Output directory file" required:"true"`
+	APIURL string `env:"SLACK_API_URL" long:"api-url" description:"Slack Web API base URL, like https://slack-gov.com/api/ for GovSlack" default:"https://slack.com/api/"`
+
+	Logging logging.Options    `group:"Logging Options"`
+	HTTP    httpclient.Options `group:"HTTP Options"`
 }
 
 var (
@@ -31,7 +39,8 @@ var (
 
 func main() {
 	if err := run(); err != nil {
-		log.Fatalf("Error: %v", err)
+		slog.Error("Could not download emoji", logging.Err(err))
+		os.Exit(1)
 	}
 }
 
@@ -40,7 +49,21 @@ func run() error {
 		return fmt.Errorf("could not parse flags: %w", err)
 	}
 
-	client := slack.New(cfg.Token)
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		return fmt.Errorf("could not set up logging: %w", err)
+	}
+
+	if !strings.HasSuffix(cfg.APIURL, "/") {
+		cfg.APIURL += "/"
+	}
+
+	httpClient, err := httpclient.New(cfg.HTTP)
+	if err != nil {
+		return fmt.Errorf("could not create HTTP client: %w", err)
+	}
+
+	client := slack.New(cfg.Token, slack.OptionHTTPClient(httpClient), slack.OptionAPIURL(cfg.APIURL))
+	slog.Debug("Calling Slack API", logging.KeyMethod, "emoji.list")
 	emoji, err := client.GetEmoji()
 	if err != nil {
 		return fmt.Errorf("could not get emoji: %w", err)
@@ -51,13 +74,14 @@ func run() error {
 			continue
 		}
 
-		err := downloadFile(id, url, cfg.Output)
+		slog.Debug("Downloading emoji", "emoji", id)
+		err := downloadFile(httpClient, id, url, cfg.Output)
 		if err != nil {
 			return fmt.Errorf("could not download file: %w", err)
 		}
 	}
 
-	f, err := os.Create(filepath.Join(cfg.Output, "emoji.json"))
+	f, err := os.Create(filepath.Join(cfg.Output, structs.EmojiFile))
 	if err != nil {
 		return fmt.Errorf("could not create file: %w", err)
 	}
@@ -73,7 +97,7 @@ func run() error {
 
 var limiter = rate.NewLimiter(rate.Every(500*time.Millisecond), 1)
 
-func downloadFile(id, fileURL, output string) error {
+func downloadFile(client *http.Client, id, fileURL, output string) error {
 	ctx := context.Background()
 	err := limiter.Wait(ctx)
 	if err != nil {
@@ -85,7 +109,7 @@ func downloadFile(id, fileURL, output string) error {
 		return fmt.Errorf("could not create request: %w", err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("could not send request: %w", err)
 	}
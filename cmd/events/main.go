@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/events"
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+type config struct {
+	Address       string   `env:"ADDRESS" long:"address" description:"Address to receive events on" default:"localhost:3000"`
+	SigningSecret string   `env:"SLACK_SIGNING_SECRET" long:"signing-secret" description:"Signing secret of the Slack app, to receive or send events"`
+	Events        string   `env:"EVENTS" long:"events" description:"Directory with the event log of each channel" default:"events"`
+	Merge         string   `long:"merge" description:"Merge the event logs into the channels of the export directory instead of receiving events"`
+	Send          []string `long:"send" description:"Sign and send a sample event file to --url instead of receiving events (can be repeated)"`
+	URL           string   `long:"url" description:"Request URL to send sample events to" default:"http://localhost:3000/"`
+	Passphrase    string   `env:"PASSPHRASE" long:"passphrase" description:"Passphrase of encrypted export files; they stay encrypted"`
+	KeyFile       string   `env:"KEY_FILE" long:"key-file" description:"Key file of encrypted export files; they stay encrypted"`
+
+	Logging logging.Options `group:"Logging Options"`
+}
+
+var (
+	cfg    config
+	secret *structs.Secret
+
+	errBadStatus       = errors.New("unexpected status code")
+	errNoSigningSecret = errors.New("signing secret is required to receive or send events")
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("Events failed", logging.Err(err))
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if _, err := flags.Parse(&cfg); err != nil {
+		return fmt.Errorf("could not parse flags: %w", err)
+	}
+
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		return fmt.Errorf("could not set up logging: %w", err)
+	}
+
+	if cfg.Merge == "" && cfg.SigningSecret == "" {
+		return errNoSigningSecret
+	}
+
+	switch {
+	case len(cfg.Send) > 0:
+		for _, path := range cfg.Send {
+			if err := send(path); err != nil {
+				return fmt.Errorf("could not send %q: %w", path, err)
+			}
+		}
+		return nil
+	case cfg.Merge != "":
+		var err error
+		secret, err = structs.LoadSecret(cfg.Passphrase, cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("could not load encryption secret: %w", err)
+		}
+		return merge(cfg.Merge)
+	}
+
+	if err := os.MkdirAll(cfg.Events, 0o755); err != nil {
+		return fmt.Errorf("could not create events directory: %w", err)
+	}
+
+	server := &http.Server{
+		Addr:              cfg.Address,
+		Handler:           events.NewReceiver(cfg.Events, cfg.SigningSecret),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	slog.Info("Receiving events", "address", cfg.Address, logging.KeyPath, cfg.Events)
+	return server.ListenAndServe()
+}
+
+// send posts the sample event file to the request URL,
+// signed like Slack does, with the current time.
+func send(path string) error {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read file: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header = events.Sign(cfg.SigningSecret, time.Now(), body)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	answer, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %d %s", errBadStatus, resp.StatusCode, strings.TrimSpace(string(answer)))
+	}
+
+	slog.Info("Sent event", logging.KeyPath, path, "response", strings.TrimSpace(string(answer)))
+	return nil
+}
+
+// merge applies the event log of every channel to its export,
+// creating exports for channels that have none.
+func merge(output string) error {
+	logs, err := filepath.Glob(filepath.Join(cfg.Events, "*"+events.LogExt))
+	if err != nil {
+		return fmt.Errorf("could not list event logs: %w", err)
+	}
+
+	index, err := structs.ReadWorkspaces(os.DirFS(output))
+	if err != nil {
+		return err
+	}
+
+	for _, logPath := range logs {
+		channelID := strings.TrimSuffix(filepath.Base(logPath), events.LogExt)
+
+		dir := output
+		if workspace := index.WorkspaceOf(channelID); workspace != "" {
+			dir = filepath.Join(output, workspace)
+		}
+
+		if err := mergeChannel(logPath, dir, channelID); err != nil {
+			return fmt.Errorf("could not merge events of channel %q: %w", channelID, err)
+		}
+	}
+
+	return nil
+}
+
+// mergeChannel applies the event log to the channel export in the
+// directory, keeping its compression and encryption.
+func mergeChannel(logPath, dir, channelID string) error {
+	entries, err := events.ReadLog(logPath)
+	if err != nil {
+		return err
+	}
+
+	path, data, encrypted, err := readChannel(dir, channelID)
+	if err != nil {
+		return err
+	}
+
+	before := len(data.Messages)
+	if err := events.Apply(data, entries); err != nil {
+		return err
+	}
+
+	slog.Info(
+		"Merged events",
+		logging.KeyChannel, channelID,
+		logging.KeyPath, path,
+		"events", len(entries),
+		"messages_before", before,
+		"messages", len(data.Messages),
+	)
+
+	content, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("could not marshal data: %w", err)
+	}
+
+	var writeSecret *structs.Secret
+	if encrypted {
+		writeSecret = secret
+	}
+
+	// write next to the file first, so that it's never left half-written;
+	// the name keeps the extension, which decides the compression
+	tmp := filepath.Join(filepath.Dir(path), ".events-"+filepath.Base(path))
+	if err := structs.WriteData(tmp, content, writeSecret); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("could not write file: %w", err)
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// readChannel reads the export of the channel in the directory,
+// compressed or not, or returns an empty channel to write there.
+func readChannel(dir, channelID string) (string, *structs.Data, bool, error) {
+	for _, name := range []string{channelID + ".json", channelID + ".json.gz"} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		encrypted, err := structs.IsEncrypted(path)
+		if err != nil {
+			return "", nil, false, fmt.Errorf("could not read file: %w", err)
+		}
+
+		data, err := structs.ReadFile(path, secret)
+		if err != nil {
+			return "", nil, false, err
+		}
+
+		return path, data, encrypted, nil
+	}
+
+	slog.Info("Channel was not exported, creating it from events", logging.KeyChannel, channelID)
+
+	data := &structs.Data{SchemaVersion: structs.SchemaVersion, Users: map[string]*slack.User{}}
+	data.Channel.ID = channelID
+	return filepath.Join(dir, channelID+".json"), data, secret != nil, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/reader"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+type config struct {
+	Input        string `long:"input" short:"i" description:"Input JSON file, directory or bundle (.zip or .tar.gz)" required:"true"`
+	Output       string `long:"output" short:"o" description:"Output CSV file; standard output if empty"`
+	Timezone     string `env:"TIMEZONE" long:"timezone" description:"Time zone for message times, like Europe/Berlin" default:"UTC"`
+	WorkspaceURL string `env:"WORKSPACE_URL" long:"workspace-url" description:"Workspace URL for permalinks, like https://example.slack.com/"`
+	Passphrase   string `env:"PASSPHRASE" long:"passphrase" description:"Passphrase to decrypt encrypted input files"`
+	KeyFile      string `env:"KEY_FILE" long:"key-file" description:"Key file to decrypt encrypted input files"`
+
+	Logging logging.Options `group:"Logging Options"`
+}
+
+var (
+	cfg config
+
+	errNoChannels = errors.New("no exported channels found")
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("Could not convert JSON to CSV", logging.Err(err))
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if _, err := flags.Parse(&cfg); err != nil {
+		return fmt.Errorf("could not parse flags: %w", err)
+	}
+
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		return fmt.Errorf("could not set up logging: %w", err)
+	}
+
+	secret, err := structs.LoadSecret(cfg.Passphrase, cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load encryption secret: %w", err)
+	}
+
+	location, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return fmt.Errorf("could not load time zone %q: %w", cfg.Timezone, err)
+	}
+
+	var (
+		w io.Writer = os.Stdout
+		f *os.File
+	)
+	if cfg.Output != "" {
+		f, err = os.Create(cfg.Output)
+		if err != nil {
+			return fmt.Errorf("could not create file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	cw := structs.NewCSVWriter(w, location, cfg.WorkspaceURL)
+
+	export, err := reader.Open(cfg.Input, reader.WithSecret(secret))
+	if err != nil {
+		return err
+	}
+	defer export.Close()
+
+	// all channels go to a single file, the channel is a column
+	count := 0
+	channels := export.Channels()
+	for channels.Next() {
+		channel := channels.Channel()
+
+		slog.Info("Processing file", logging.KeyPath, channel.Name)
+		if err := cw.Write(channel.Data); err != nil {
+			return fmt.Errorf("could not write rows of %q: %w", channel.Name, err)
+		}
+		count++
+	}
+	if err := channels.Err(); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return fmt.Errorf("%w: %s", errNoChannels, cfg.Input)
+	}
+
+	if err := cw.Flush(); err != nil {
+		return fmt.Errorf("could not write rows: %w", err)
+	}
+
+	if f == nil {
+		return nil
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("could not write file: %w", err)
+	}
+
+	return nil
+}
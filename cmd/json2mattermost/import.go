@@ -0,0 +1,552 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// Mattermost bulk import records, one per line.
+// See https://docs.mattermost.com/onboard/bulk-loading-data.html
+type line struct {
+	Type          string         `json:"type"`
+	Version       int            `json:"version,omitempty"`
+	Emoji         *emojiImport   `json:"emoji,omitempty"`
+	Team          *team          `json:"team,omitempty"`
+	Channel       *channel       `json:"channel,omitempty"`
+	User          *user          `json:"user,omitempty"`
+	Post          *post          `json:"post,omitempty"`
+	DirectChannel *directChannel `json:"direct_channel,omitempty"`
+	DirectPost    *directPost    `json:"direct_post,omitempty"`
+}
+
+type emojiImport struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+}
+
+type team struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Type        string `json:"type"`
+}
+
+type channel struct {
+	Team        string `json:"team"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Type        string `json:"type"`
+	Header      string `json:"header,omitempty"`
+	Purpose     string `json:"purpose,omitempty"`
+}
+
+type user struct {
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	Nickname  string     `json:"nickname,omitempty"`
+	FirstName string     `json:"first_name,omitempty"`
+	LastName  string     `json:"last_name,omitempty"`
+	Position  string     `json:"position,omitempty"`
+	Roles     string     `json:"roles"`
+	DeleteAt  int64      `json:"delete_at,omitempty"`
+	Teams     []userTeam `json:"teams"`
+}
+
+type userTeam struct {
+	Name     string        `json:"name"`
+	Roles    string        `json:"roles"`
+	Channels []userChannel `json:"channels"`
+}
+
+type userChannel struct {
+	Name  string `json:"name"`
+	Roles string `json:"roles"`
+}
+
+type post struct {
+	Team        string       `json:"team"`
+	Channel     string       `json:"channel"`
+	User        string       `json:"user"`
+	Message     string       `json:"message"`
+	CreateAt    int64        `json:"create_at"`
+	Reactions   []reaction   `json:"reactions,omitempty"`
+	Replies     []reply      `json:"replies,omitempty"`
+	Attachments []attachment `json:"attachments,omitempty"`
+}
+
+type reply struct {
+	User        string       `json:"user"`
+	Message     string       `json:"message"`
+	CreateAt    int64        `json:"create_at"`
+	Reactions   []reaction   `json:"reactions,omitempty"`
+	Attachments []attachment `json:"attachments,omitempty"`
+}
+
+type reaction struct {
+	User      string `json:"user"`
+	EmojiName string `json:"emoji_name"`
+	CreateAt  int64  `json:"create_at"`
+}
+
+type attachment struct {
+	Path string `json:"path"`
+}
+
+type directChannel struct {
+	Members []string `json:"members"`
+	Header  string   `json:"header,omitempty"`
+}
+
+type directPost struct {
+	ChannelMembers []string     `json:"channel_members"`
+	User           string       `json:"user"`
+	Message        string       `json:"message"`
+	CreateAt       int64        `json:"create_at"`
+	Reactions      []reaction   `json:"reactions,omitempty"`
+	Replies        []reply      `json:"replies,omitempty"`
+	Attachments    []attachment `json:"attachments,omitempty"`
+}
+
+// maxGroupMembers is the largest group message channel in Mattermost.
+const maxGroupMembers = 8
+
+var (
+	invalidUsername    = regexp.MustCompile(`[^a-z0-9._-]+`)
+	invalidChannelName = regexp.MustCompile(`[^a-z0-9_-]+`)
+	invalidEmojiName   = regexp.MustCompile(`[^a-z0-9_+-]+`)
+)
+
+// file is an attachment to copy into the import archive.
+type file struct {
+	channelID string
+	name      string // file ID and name, as downloaded by the exporter
+}
+
+// importer converts exported channels to Mattermost records.
+// Users are collected while converting, as posts and reactions
+// may reference users that were not exported.
+type importer struct {
+	team   string
+	domain string
+	emoji  structs.EmojiMap
+
+	usernames map[string]string // Slack user ID -> Mattermost username
+	taken     map[string]bool   // Mattermost usernames in use
+	users     map[string]*slack.User
+	bots      map[string]string // Mattermost username -> bot name
+	channels  map[string]string // Slack channel ID -> Mattermost channel name
+	members   map[string]map[string]bool
+
+	// mentions resolves user mentions in text to Mattermost usernames
+	mentions map[string]*slack.User
+
+	lines []line
+	files []file
+}
+
+func newImporter(teamName string, domain string, emoji structs.EmojiMap, data []*structs.Data) *importer {
+	im := &importer{
+		team:      teamName,
+		domain:    domain,
+		emoji:     emoji,
+		usernames: map[string]string{},
+		taken:     map[string]bool{},
+		users:     map[string]*slack.User{},
+		bots:      map[string]string{},
+		channels:  map[string]string{},
+		members:   map[string]map[string]bool{},
+		mentions:  map[string]*slack.User{},
+	}
+
+	for _, d := range data {
+		for id, u := range d.Users {
+			if u != nil {
+				im.users[id] = u
+			}
+		}
+	}
+
+	// assign usernames in a stable order, so that collisions
+	// are resolved the same way on every run
+	ids := make([]string, 0, len(im.users))
+	for id := range im.users {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		im.username(id)
+	}
+
+	takenChannels := map[string]bool{}
+	for _, d := range data {
+		if d.Channel.IsIM || d.Channel.IsMpIM {
+			continue
+		}
+		name := uniqueName(sanitize(invalidChannelName, cmp.Or(d.Channel.Name, d.Channel.ID), 64), takenChannels, 64)
+		im.channels[d.Channel.ID] = name
+	}
+
+	return im
+}
+
+// username returns the Mattermost username for the Slack user ID,
+// registering a placeholder user if it wasn't exported.
+func (im *importer) username(id string) string {
+	if name, ok := im.usernames[id]; ok {
+		return name
+	}
+
+	handle := id
+	if u, ok := im.users[id]; ok && u.Name != "" {
+		handle = u.Name
+	}
+
+	name := uniqueName(sanitizeUsername(handle), im.taken, 22)
+	im.usernames[id] = name
+	im.mentions[id] = &slack.User{ID: id, Name: name}
+
+	return name
+}
+
+// botUsername returns the username for messages posted by a bot without a user.
+func (im *importer) botUsername(m slack.Message) string {
+	bot := cmp.Or(m.Username, m.BotID, "bot")
+	id := "bot:" + cmp.Or(m.BotID, bot)
+
+	if name, ok := im.usernames[id]; ok {
+		return name
+	}
+
+	name := uniqueName(sanitizeUsername(bot), im.taken, 22)
+	im.usernames[id] = name
+	im.bots[name] = bot
+
+	return name
+}
+
+func (im *importer) author(m slack.Message) string {
+	if m.User == "" {
+		return im.botUsername(m)
+	}
+
+	return im.username(m.User)
+}
+
+// addChannel converts the channel and all its messages.
+func (im *importer) addChannel(d *structs.Data) {
+	messages := make([]structs.Message, len(d.Messages))
+	copy(messages, d.Messages)
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Timestamp < messages[j].Timestamp
+	})
+
+	if d.Channel.IsIM || d.Channel.IsMpIM {
+		im.addDirectChannel(d, messages)
+		return
+	}
+
+	name := im.channels[d.Channel.ID]
+	channelType := "O"
+	if d.Channel.IsPrivate {
+		channelType = "P"
+	}
+
+	im.lines = append(im.lines, line{Type: "channel", Channel: &channel{
+		Team:        im.team,
+		Name:        name,
+		DisplayName: truncate(cmp.Or(d.Channel.Name, d.Channel.ID), 64),
+		Type:        channelType,
+		Header:      truncate(d.Channel.Topic.Value, 1024),
+		Purpose:     truncate(d.Channel.Purpose.Value, 250),
+	}})
+
+	// users of the channel, like everyone who posted in it, become its members
+	for id := range d.Users {
+		im.join(name, im.username(id))
+	}
+
+	for _, m := range messages {
+		p := &post{
+			Team:        im.team,
+			Channel:     name,
+			User:        im.author(m.Message),
+			Message:     im.text(d, m.Message),
+			CreateAt:    createAt(m.Timestamp),
+			Reactions:   im.reactions(m.Message),
+			Attachments: im.attachments(d, m.Message),
+			Replies:     im.replies(d, m),
+		}
+		im.join(name, p.User)
+		for _, r := range p.Replies {
+			im.join(name, r.User)
+		}
+
+		im.lines = append(im.lines, line{Type: "post", Post: p})
+	}
+}
+
+// addDirectChannel converts direct and group messages, with the members
+// taken from the channel, its name and the authors of the messages.
+func (im *importer) addDirectChannel(d *structs.Data, messages []structs.Message) {
+	members := map[string]bool{}
+	if d.Channel.User != "" {
+		members[im.username(d.Channel.User)] = true
+	}
+
+	// group messages are named like mpdm-alice--bob--carol-1
+	if d.Channel.IsMpIM {
+		handles := strings.TrimSuffix(strings.TrimPrefix(d.Channel.Name, "mpdm-"), "-1")
+		for _, handle := range strings.Split(handles, "--") {
+			for id, u := range im.users {
+				if u.Name == handle {
+					members[im.username(id)] = true
+				}
+			}
+		}
+	}
+
+	for _, m := range messages {
+		members[im.author(m.Message)] = true
+	}
+
+	list := make([]string, 0, len(members))
+	for member := range members {
+		list = append(list, member)
+	}
+	sort.Strings(list)
+
+	switch {
+	case len(list) == 0:
+		return
+	case len(list) == 1:
+		// messages to yourself
+		list = append(list, list[0])
+	case len(list) > maxGroupMembers:
+		list = list[:maxGroupMembers]
+	}
+
+	im.lines = append(im.lines, line{Type: "direct_channel", DirectChannel: &directChannel{
+		Members: list,
+		Header:  truncate(d.Channel.Topic.Value, 1024),
+	}})
+
+	for _, m := range messages {
+		im.lines = append(im.lines, line{Type: "direct_post", DirectPost: &directPost{
+			ChannelMembers: list,
+			User:           im.author(m.Message),
+			Message:        im.text(d, m.Message),
+			CreateAt:       createAt(m.Timestamp),
+			Reactions:      im.reactions(m.Message),
+			Attachments:    im.attachments(d, m.Message),
+			Replies:        im.replies(d, m),
+		}})
+	}
+}
+
+func (im *importer) replies(d *structs.Data, m structs.Message) []reply {
+	var result []reply
+
+	for _, r := range m.Replies {
+		// conversations.replies includes the parent
+		if r.Timestamp == m.Timestamp {
+			continue
+		}
+
+		result = append(result, reply{
+			User:        im.author(r),
+			Message:     im.text(d, r),
+			CreateAt:    createAt(r.Timestamp),
+			Reactions:   im.reactions(r),
+			Attachments: im.attachments(d, r),
+		})
+	}
+
+	return result
+}
+
+// text converts the message to plain text, with mentions
+// of users and channels in the Mattermost syntax.
+func (im *importer) text(d *structs.Data, m slack.Message) string {
+	text := structs.PlainText(m, im.mentions)
+
+	for id, name := range im.channels {
+		text = strings.ReplaceAll(text, "#"+id, "~"+name)
+	}
+
+	// files that weren't downloaded can't be attached
+	for _, f := range m.Files {
+		if _, ok := d.Files[f.ID]; !ok {
+			text += fmt.Sprintf("\n\n[%s](%s)", cmp.Or(f.Title, f.Name, f.ID), cmp.Or(f.URLPrivateDownload, f.URLPrivate))
+		}
+	}
+
+	return text
+}
+
+func (im *importer) reactions(m slack.Message) []reaction {
+	var result []reaction
+
+	createAt := createAt(m.Timestamp)
+	for _, r := range m.Reactions {
+		name := im.emojiName(r.Name)
+		for _, id := range r.Users {
+			result = append(result, reaction{User: im.username(id), EmojiName: name, CreateAt: createAt})
+		}
+	}
+
+	return result
+}
+
+// emojiName maps a Slack emoji to Mattermost: skin tones are dropped
+// and aliases of custom emoji are resolved.
+func (im *importer) emojiName(name string) string {
+	name, _, _ = strings.Cut(name, "::skin-tone-")
+
+	if alias, _ := im.emoji.Get(name); alias != "" {
+		name = alias
+	}
+
+	return sanitize(invalidEmojiName, name, 64)
+}
+
+func (im *importer) attachments(d *structs.Data, m slack.Message) []attachment {
+	var result []attachment
+
+	for _, f := range m.Files {
+		filename, ok := d.Files[f.ID]
+		if !ok {
+			continue
+		}
+
+		name := f.ID + "-" + filename
+		im.files = append(im.files, file{channelID: d.Channel.ID, name: name})
+		result = append(result, attachment{Path: path.Join(d.Channel.ID, name)})
+	}
+
+	return result
+}
+
+func (im *importer) join(channelName, username string) {
+	if im.members[username] == nil {
+		im.members[username] = map[string]bool{}
+	}
+	im.members[username][channelName] = true
+}
+
+// userLines returns the users with their team and channel memberships.
+func (im *importer) userLines() []line {
+	byName := make(map[string]string, len(im.usernames))
+	for id, name := range im.usernames {
+		byName[name] = id
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]line, 0, len(names))
+	for _, name := range names {
+		u := &user{
+			Username: name,
+			Email:    name + "@" + im.domain,
+			Roles:    "system_user",
+			Teams:    []userTeam{{Name: im.team, Roles: "team_user", Channels: []userChannel{}}},
+		}
+
+		if bot, ok := im.bots[name]; ok {
+			u.Nickname = bot
+		}
+
+		if su, ok := im.users[byName[name]]; ok {
+			if su.Profile.Email != "" {
+				u.Email = su.Profile.Email
+			}
+			u.Nickname = su.Profile.DisplayName
+			u.FirstName = su.Profile.FirstName
+			u.LastName = su.Profile.LastName
+			u.Position = truncate(su.Profile.Title, 128)
+			if su.Deleted {
+				// the time of deletion is not exported
+				u.DeleteAt = 1
+			}
+		}
+
+		channels := make([]string, 0, len(im.members[name]))
+		for channelName := range im.members[name] {
+			channels = append(channels, channelName)
+		}
+		sort.Strings(channels)
+		for _, channelName := range channels {
+			u.Teams[0].Channels = append(u.Teams[0].Channels, userChannel{Name: channelName, Roles: "channel_user"})
+		}
+
+		result = append(result, line{Type: "user", User: u})
+	}
+
+	return result
+}
+
+// createAt converts the Slack ts to milliseconds since the epoch.
+func createAt(ts string) int64 {
+	t, err := structs.ParseTimestamp(ts)
+	if err != nil {
+		return 0
+	}
+
+	return t.UnixMilli()
+}
+
+// sanitize lowercases s and replaces the characters Mattermost doesn't allow.
+func sanitize(invalid *regexp.Regexp, s string, maxLength int) string {
+	s = invalid.ReplaceAllString(strings.ToLower(s), "-")
+	s = strings.Trim(s, "-")
+	for len(s) < 2 {
+		s += "_"
+	}
+
+	return truncate(s, maxLength)
+}
+
+// sanitizeUsername makes the handle a valid Mattermost username:
+// at least 3 characters, starting with a letter.
+func sanitizeUsername(handle string) string {
+	name := sanitize(invalidUsername, handle, 22)
+	if name[0] < 'a' || name[0] > 'z' {
+		name = truncate("u"+name, 22)
+	}
+	for len(name) < 3 {
+		name += "_"
+	}
+
+	return name
+}
+
+// uniqueName appends a number to the name if it is taken, and marks it taken.
+func uniqueName(name string, taken map[string]bool, maxLength int) string {
+	candidate := name
+	for i := 2; taken[candidate]; i++ {
+		suffix := strconv.Itoa(i)
+		candidate = truncate(name, maxLength-len(suffix)) + suffix
+	}
+	taken[candidate] = true
+
+	return candidate
+}
+
+func truncate(s string, maxLength int) string {
+	if r := []rune(s); len(r) > maxLength {
+		return string(r[:maxLength])
+	}
+
+	return s
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/reader"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// importName is the name of the JSONL file in the import archive;
+// attachments and emoji are stored under dataDir.
+const (
+	importName = "import.jsonl"
+	dataDir    = "data"
+)
+
+type config struct {
+	Input           string `long:"input" short:"i" description:"Input JSON file, directory or bundle (.zip or .tar.gz)" required:"true"`
+	Output          string `long:"output" short:"o" description:"Output import archive (.zip)" default:"mattermost-import.zip"`
+	Team            string `long:"team" description:"Name of the Mattermost team to import into" default:"slack"`
+	TeamDisplayName string `long:"team-display-name" description:"Display name of the team" default:"Slack"`
+	EmojiDir        string `long:"emoji" description:"Directory with custom emoji downloaded by the emoji tool" default:"emoji"`
+	Domain          string `env:"MAIL_DOMAIN" long:"domain" description:"Email domain for users without an exported email" default:"slack.invalid"`
+	Passphrase      string `env:"PASSPHRASE" long:"passphrase" description:"Passphrase to decrypt encrypted input files"`
+	KeyFile         string `env:"KEY_FILE" long:"key-file" description:"Key file to decrypt encrypted input files"`
+
+	Logging logging.Options `group:"Logging Options"`
+}
+
+var (
+	cfg    config
+	secret *structs.Secret
+
+	errNoChannels = errors.New("no exported channels found")
+)
+
+// lineOrder is the order of the record types the importer expects.
+var lineOrder = map[string]int{
+	"version":        0,
+	"emoji":          1,
+	"team":           2,
+	"channel":        3,
+	"user":           4,
+	"post":           5,
+	"direct_channel": 6,
+	"direct_post":    7,
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("Could not convert JSON to Mattermost import", logging.Err(err))
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if _, err := flags.Parse(&cfg); err != nil {
+		return fmt.Errorf("could not parse flags: %w", err)
+	}
+
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		return fmt.Errorf("could not set up logging: %w", err)
+	}
+
+	var err error
+	secret, err = structs.LoadSecret(cfg.Passphrase, cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load encryption secret: %w", err)
+	}
+
+	var emoji structs.EmojiMap
+	if cfg.EmojiDir != "" {
+		emoji, err = structs.LoadEmoji(cfg.EmojiDir)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("could not load emoji: %w", err)
+			}
+			slog.Info("Emoji file not found, skipping custom emoji", logging.KeyPath, cfg.EmojiDir)
+		}
+	}
+
+	export, err := reader.Open(cfg.Input, reader.WithSecret(secret))
+	if err != nil {
+		return err
+	}
+	defer export.Close()
+
+	// directories of the channels in the export, where their attachments are
+	var data []*structs.Data
+	dirs := map[string]string{}
+
+	channels := export.Channels()
+	for channels.Next() {
+		channel := channels.Channel()
+		slog.Info("Reading file", logging.KeyPath, channel.Name)
+		data = append(data, channel.Data)
+		dirs[channel.Data.Channel.ID] = channel.Dir()
+	}
+	if err := channels.Err(); err != nil {
+		return err
+	}
+
+	if len(data) == 0 {
+		return fmt.Errorf("%w: %s", errNoChannels, cfg.Input)
+	}
+
+	im := newImporter(cfg.Team, cfg.Domain, emoji, data)
+	for _, d := range data {
+		im.addChannel(d)
+	}
+
+	lines := []line{
+		{Type: "version", Version: 1},
+		{Type: "team", Team: &team{Name: cfg.Team, DisplayName: cfg.TeamDisplayName, Type: "I"}},
+	}
+	lines = append(lines, emojiLines(emoji)...)
+	lines = append(lines, im.lines...)
+	lines = append(lines, im.userLines()...)
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lineOrder[lines[i].Type] < lineOrder[lines[j].Type]
+	})
+
+	slog.Info("Writing import archive", logging.KeyPath, cfg.Output, "records", len(lines), "attachments", len(im.files))
+	return writeArchive(cfg.Output, lines, export.FS(), dirs, im.files, emoji)
+}
+
+// emojiLines lists the custom emoji; aliases are resolved in reactions instead.
+func emojiLines(emoji structs.EmojiMap) []line {
+	names := make([]string, 0, len(emoji))
+	for name := range emoji {
+		if _, filename := emoji.Get(name); filename != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	lines := make([]line, 0, len(names))
+	for _, name := range names {
+		_, filename := emoji.Get(name)
+		lines = append(lines, line{Type: "emoji", Emoji: &emojiImport{
+			Name:  sanitize(invalidEmojiName, name, 64),
+			Image: path.Join("emoji", filename),
+		}})
+	}
+
+	return lines
+}
+
+// writeArchive writes the import archive: the JSONL file,
+// and the attachments and emoji images it references.
+// dirs maps channel IDs to their directories in input.
+func writeArchive(output string, lines []line, input fs.FS, dirs map[string]string, files []file, emoji structs.EmojiMap) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("could not create archive: %w", err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+
+	w, err := zw.Create(importName)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	for _, l := range lines {
+		if err := enc.Encode(l); err != nil {
+			return fmt.Errorf("could not write record: %w", err)
+		}
+	}
+
+	// the same file can be shared in several messages
+	seen := map[string]bool{}
+	for _, file := range files {
+		p := path.Join(file.channelID, file.name)
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		if err := copyToArchive(zw, path.Join(dataDir, p), func() (io.ReadCloser, error) {
+			src, err := input.Open(path.Join(dirs[file.channelID], p))
+			if err != nil {
+				return nil, err
+			}
+
+			// attachments are encrypted along with the channels
+			r, err := structs.OpenReader(src, secret)
+			if err != nil {
+				src.Close()
+				return nil, err
+			}
+
+			return struct {
+				io.Reader
+				io.Closer
+			}{r, src}, nil
+		}); err != nil {
+			slog.Warn("Could not add attachment", logging.KeyPath, p, logging.Err(err))
+		}
+	}
+
+	for name := range emoji {
+		_, filename := emoji.Get(name)
+		if filename == "" {
+			continue
+		}
+
+		err := copyToArchive(zw, path.Join(dataDir, "emoji", filename), func() (io.ReadCloser, error) {
+			return os.Open(filepath.Join(cfg.EmojiDir, filename))
+		})
+		if err != nil {
+			slog.Warn("Could not add emoji", "emoji", name, logging.Err(err))
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("could not write archive: %w", err)
+	}
+
+	return f.Close()
+}
+
+func copyToArchive(zw *zip.Writer, name string, open func() (io.ReadCloser, error)) error {
+	src, err := open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, src)
+	return err
+}
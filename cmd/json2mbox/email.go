@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/reader"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// maxSubjectLength keeps the subject on one line in most mail clients.
+const maxSubjectLength = 70
+
+// email is a single message converted to RFC 5322.
+type email struct {
+	ts      string
+	from    string
+	date    time.Time
+	content []byte
+}
+
+// converter turns the messages of a channel into emails.
+// Attachments are read from the export, next to the channel.
+type converter struct {
+	data    *structs.Data
+	channel *reader.Channel
+	domain  string
+}
+
+// messageID returns the Message-ID of the message, built from the Slack ts,
+// so that replies can reference their parent.
+func (c *converter) messageID(ts string) string {
+	return "<" + ts + "@" + c.data.Channel.ID + "." + c.domain + ">"
+}
+
+func (c *converter) address(userID string, m slack.Message) *mail.Address {
+	if userID == "" {
+		// bots without a user
+		name := cmp.Or(m.Username, m.BotID, "unknown")
+		return &mail.Address{Name: name, Address: cmp.Or(m.BotID, "bot") + "@" + c.domain}
+	}
+
+	user := c.data.Users[userID]
+	address := userID + "@" + c.domain
+	if user != nil && user.Profile.Email != "" {
+		address = user.Profile.Email
+	}
+
+	return &mail.Address{Name: structs.UserName(user), Address: address}
+}
+
+// emails converts all messages of the channel, oldest first,
+// with every thread parent followed by its replies.
+func (c *converter) emails() ([]email, error) {
+	messages := make([]structs.Message, len(c.data.Messages))
+	copy(messages, c.data.Messages)
+	// messages are exported newest first
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	var result []email
+	for _, m := range messages {
+		subject := subjectOf(structs.PlainText(m.Message, c.data.Users))
+
+		e, err := c.email(m.Message, subject, nil)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+
+		for _, reply := range m.Replies {
+			// conversations.replies includes the parent
+			if reply.Timestamp == m.Timestamp {
+				continue
+			}
+
+			e, err := c.email(reply, "Re: "+subject, &m.Message)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, e)
+		}
+	}
+
+	return result, nil
+}
+
+func (c *converter) email(m slack.Message, subject string, parent *slack.Message) (email, error) {
+	date, err := structs.ParseTimestamp(m.Timestamp)
+	if err != nil {
+		return email{}, fmt.Errorf("could not parse ts %q: %w", m.Timestamp, err)
+	}
+
+	from := c.address(m.User, m)
+	channelName := structs.ChannelName(c.data.Channel, c.data.Users)
+	to := &mail.Address{Name: channelName, Address: c.data.Channel.ID + "@" + c.domain}
+
+	buf := &bytes.Buffer{}
+	header := func(key, value string) {
+		fmt.Fprintf(buf, "%s: %s\r\n", key, value)
+	}
+
+	header("From", from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", c.messageID(m.Timestamp))
+	header("List-Id", mime.QEncoding.Encode("utf-8", channelName)+" <"+c.data.Channel.ID+"."+c.domain+">")
+	if parent != nil {
+		header("In-Reply-To", c.messageID(parent.Timestamp))
+		header("References", c.messageID(parent.Timestamp))
+	}
+	header("X-Slack-Channel", c.data.Channel.ID)
+	header("X-Slack-Ts", m.Timestamp)
+	header("MIME-Version", "1.0")
+
+	text := structs.PlainText(m, c.data.Users)
+	for _, file := range m.Files {
+		if _, ok := c.data.Files[file.ID]; !ok {
+			text += "\n\nFile: " + cmp.Or(file.Name, file.Title, file.ID) + " " + cmp.Or(file.URLPrivateDownload, file.URLPrivate)
+		}
+	}
+
+	attachments := c.attachments(m)
+
+	if len(attachments) == 0 {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(buf, text); err != nil {
+			return email{}, err
+		}
+		return email{ts: m.Timestamp, from: from.Address, date: date, content: buf.Bytes()}, nil
+	}
+
+	mw := multipart.NewWriter(buf)
+	header("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+
+	pw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return email{}, err
+	}
+	if err := writeQuotedPrintable(pw, text); err != nil {
+		return email{}, err
+	}
+
+	for _, a := range attachments {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {cmp.Or(a.mimetype, "application/octet-stream")},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.name})},
+		})
+		if err != nil {
+			return email{}, err
+		}
+		writeBase64(pw, a.content)
+	}
+
+	if err := mw.Close(); err != nil {
+		return email{}, err
+	}
+
+	return email{ts: m.Timestamp, from: from.Address, date: date, content: buf.Bytes()}, nil
+}
+
+type attachment struct {
+	name     string
+	mimetype string
+	content  []byte
+}
+
+// attachments reads the downloaded files of the message.
+// Files that were not downloaded are linked in the text instead.
+func (c *converter) attachments(m slack.Message) []attachment {
+	var result []attachment
+
+	for _, file := range m.Files {
+		filename, ok := c.data.Files[file.ID]
+		if !ok {
+			continue
+		}
+
+		p := c.channel.FilePath(file.ID)
+		content, err := readAttachment(c.channel, file.ID)
+		if err != nil {
+			slog.Warn("Could not read attachment", logging.KeyFile, file.ID, logging.KeyPath, p, logging.Err(err))
+			continue
+		}
+
+		result = append(result, attachment{
+			name:     cmp.Or(file.Name, filename),
+			mimetype: file.Mimetype,
+			content:  content,
+		})
+	}
+
+	return result
+}
+
+func readAttachment(channel *reader.Channel, fileID string) ([]byte, error) {
+	f, err := channel.OpenFile(fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// subjectOf returns the first line of the text, shortened.
+func subjectOf(text string) string {
+	subject, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	if subject == "" {
+		return "(no text)"
+	}
+
+	if r := []rune(subject); len(r) > maxSubjectLength {
+		subject = string(r[:maxSubjectLength]) + "…"
+	}
+
+	return subject
+}
+
+func writeQuotedPrintable(w io.Writer, text string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n"))); err != nil {
+		return err
+	}
+
+	return qw.Close()
+}
+
+// writeBase64 writes content in lines of 76 characters, as MIME requires.
+func writeBase64(w io.Writer, content []byte) {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > 76 {
+		fmt.Fprintf(w, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(w, "%s\r\n", encoded)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/reader"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+type config struct {
+	Input      string `long:"input" short:"i" description:"Input JSON file, directory or bundle (.zip or .tar.gz)" required:"true"`
+	Output     string `long:"output" short:"o" description:"Output mbox file, or directory for .eml files" required:"true"`
+	EML        bool   `long:"eml" description:"Write every message as a separate .eml file instead of a single mbox"`
+	Domain     string `env:"MAIL_DOMAIN" long:"domain" description:"Domain for addresses and Message-IDs without an email" default:"slack.invalid"`
+	Passphrase string `env:"PASSPHRASE" long:"passphrase" description:"Passphrase to decrypt encrypted input files"`
+	KeyFile    string `env:"KEY_FILE" long:"key-file" description:"Key file to decrypt encrypted input files"`
+
+	Logging logging.Options `group:"Logging Options"`
+}
+
+var (
+	cfg    config
+	secret *structs.Secret
+
+	// mboxFrom matches the lines that would be taken for the start
+	// of the next message; they are quoted as in the mboxrd format
+	mboxFrom = regexp.MustCompile(`(?m)^(>*From )`)
+
+	errNoChannels = errors.New("no exported channels found")
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("Could not convert JSON to mbox", logging.Err(err))
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if _, err := flags.Parse(&cfg); err != nil {
+		return fmt.Errorf("could not parse flags: %w", err)
+	}
+
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		return fmt.Errorf("could not set up logging: %w", err)
+	}
+
+	var err error
+	secret, err = structs.LoadSecret(cfg.Passphrase, cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load encryption secret: %w", err)
+	}
+
+	var w emailWriter
+	if cfg.EML {
+		w = &emlWriter{dir: cfg.Output}
+	} else {
+		mw, err := newMboxWriter(cfg.Output)
+		if err != nil {
+			return err
+		}
+		w = mw
+	}
+
+	if err := convert(w); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+func convert(w emailWriter) error {
+	export, err := reader.Open(cfg.Input, reader.WithSecret(secret))
+	if err != nil {
+		return err
+	}
+	defer export.Close()
+
+	count := 0
+
+	channels := export.Channels()
+	for channels.Next() {
+		channel := channels.Channel()
+
+		slog.Info("Processing file", logging.KeyPath, channel.Name)
+		if err := processChannel(w, channel); err != nil {
+			return fmt.Errorf("could not process file %q: %w", channel.Name, err)
+		}
+		count++
+	}
+	if err := channels.Err(); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return fmt.Errorf("%w: %s", errNoChannels, cfg.Input)
+	}
+
+	return nil
+}
+
+func processChannel(w emailWriter, channel *reader.Channel) error {
+	data := channel.Data
+
+	c := &converter{data: data, channel: channel, domain: cfg.Domain}
+	emails, err := c.emails()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range emails {
+		if err := w.Write(data.Channel.ID, e); err != nil {
+			return fmt.Errorf("could not write message %q: %w", e.ts, err)
+		}
+	}
+
+	return nil
+}
+
+// emailWriter stores converted messages.
+type emailWriter interface {
+	Write(channelID string, e email) error
+	Close() error
+}
+
+// mboxWriter appends all messages to a single mbox file.
+type mboxWriter struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func newMboxWriter(path string) (*mboxWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not create mbox: %w", err)
+	}
+
+	return &mboxWriter{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (m *mboxWriter) Write(_ string, e email) error {
+	// mbox files use the line endings of the system
+	content := bytes.ReplaceAll(e.content, []byte("\r\n"), []byte("\n"))
+	content = mboxFrom.ReplaceAll(content, []byte(">$1"))
+
+	if _, err := fmt.Fprintf(m.w, "From %s %s\n", e.from, e.date.UTC().Format(time.ANSIC)); err != nil {
+		return err
+	}
+
+	if _, err := m.w.Write(content); err != nil {
+		return err
+	}
+
+	if !bytes.HasSuffix(content, []byte("\n")) {
+		m.w.WriteString("\n")
+	}
+
+	_, err := m.w.WriteString("\n")
+	return err
+}
+
+func (m *mboxWriter) Close() error {
+	if err := m.w.Flush(); err != nil {
+		m.f.Close()
+		return err
+	}
+
+	return m.f.Close()
+}
+
+// emlWriter writes every message to <channel>/<ts>.eml.
+type emlWriter struct {
+	dir string
+}
+
+func (w *emlWriter) Write(channelID string, e email) error {
+	dir := filepath.Join(w.dir, channelID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	return os.WriteFile(filepath.Join(dir, e.ts+".eml"), e.content, 0o644)
+}
+
+func (w *emlWriter) Close() error { return nil }
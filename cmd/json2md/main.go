@@ -0,0 +1,382 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/reader"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+const (
+	splitChannel = "channel"
+	splitDay     = "day"
+)
+
+type config struct {
+	Input        string `long:"input" short:"i" description:"Input JSON file, directory or bundle (.zip or .tar.gz)" required:"true"`
+	Output       string `long:"output" short:"o" description:"Output Markdown file or directory"`
+	Split        string `long:"split" description:"Write one Markdown file per channel, or per channel and day" choice:"channel" choice:"day" default:"channel"`
+	Timezone     string `env:"TIMEZONE" long:"timezone" description:"Time zone for message times and days, like Europe/Berlin" default:"UTC"`
+	EmojiDir     string `long:"emoji" description:"Directory with emoji" default:"emoji"`
+	SkipArchived bool   `long:"skip-archived" description:"Skip archived channels"`
+	Passphrase   string `env:"PASSPHRASE" long:"passphrase" description:"Passphrase to decrypt encrypted input files"`
+	KeyFile      string `env:"KEY_FILE" long:"key-file" description:"Key file to decrypt encrypted input files"`
+
+	Logging logging.Options `group:"Logging Options"`
+}
+
+var (
+	errChannelIsArchived = fmt.Errorf("channel is archived")
+	errNoMessages        = fmt.Errorf("no messages")
+	errNoChannels        = fmt.Errorf("no exported channels found")
+)
+
+var (
+	cfg        config
+	secret     *structs.Secret
+	location   *time.Location
+	slackEmoji structs.EmojiMap
+
+	// copyAssets is set when attachments and emoji are copied
+	// next to the Markdown files
+	copyAssets bool
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("Could not convert JSON to Markdown", logging.Err(err))
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if _, err := flags.Parse(&cfg); err != nil {
+		return fmt.Errorf("could not parse flags: %w", err)
+	}
+
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		return fmt.Errorf("could not set up logging: %w", err)
+	}
+
+	var err error
+	secret, err = structs.LoadSecret(cfg.Passphrase, cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load encryption secret: %w", err)
+	}
+
+	location, err = time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return fmt.Errorf("could not load time zone %q: %w", cfg.Timezone, err)
+	}
+
+	if cfg.EmojiDir != "" {
+		slackEmoji, err = structs.LoadEmoji(cfg.EmojiDir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				slog.Info("Emoji file not found, skipping", logging.KeyPath, cfg.EmojiDir)
+			} else {
+				return fmt.Errorf("could not load emoji: %w", err)
+			}
+		}
+	}
+
+	// check if input is a file, a directory or a bundle
+	info, err := os.Stat(cfg.Input)
+	if err != nil {
+		return fmt.Errorf("could not get file info: %w", err)
+	}
+
+	export, err := reader.Open(cfg.Input, reader.WithSecret(secret))
+	if err != nil {
+		return err
+	}
+	defer export.Close()
+
+	// resolves mentions of channels that come later in the export
+	if _, err := export.ChannelNames(); err != nil {
+		return err
+	}
+
+	if !info.IsDir() && !structs.IsBundle(cfg.Input) {
+		output := cfg.Output
+		if output == "" {
+			output = filepath.Dir(cfg.Input)
+			if cfg.Split == splitChannel {
+				output = filepath.Join(output, structs.TrimDataExt(filepath.Base(cfg.Input))+".md")
+			}
+		}
+
+		// the directory links are relative to
+		outputDir := output
+		if cfg.Split == splitChannel {
+			outputDir = filepath.Dir(output)
+		}
+		setCopyAssets(filepath.Dir(cfg.Input), outputDir)
+
+		channels := export.Channels()
+		if !channels.Next() {
+			return cmp.Or(channels.Err(), fmt.Errorf("%w: %s", errNoChannels, cfg.Input))
+		}
+
+		channel := channels.Channel()
+		if err := processChannel(channel, output); err != nil {
+			return fmt.Errorf("could not process file %q: %w", cfg.Input, err)
+		}
+
+		copyChannelAssets(channel, outputDir)
+		copyEmoji(outputDir)
+		return nil
+	}
+
+	if cfg.Output == "" {
+		cfg.Output = strings.TrimSuffix(strings.TrimSuffix(cfg.Input, "."+structs.BundleZip), "."+structs.BundleTarGz)
+	}
+
+	setCopyAssets(cfg.Input, cfg.Output)
+
+	if err := processExport(export, cfg.Output); err != nil {
+		return err
+	}
+
+	copyEmoji(cfg.Output)
+	return nil
+}
+
+// setCopyAssets decides whether attachments and emoji are copied next to
+// the Markdown files: unless the output is the directory of the input,
+// where the links find them, they are copied, decrypted if needed.
+func setCopyAssets(inputDir, outputDir string) {
+	switch {
+	case structs.IsBundle(cfg.Input) || !sameDir(inputDir, outputDir):
+		copyAssets = true
+	case secret != nil:
+		slog.Warn("Attachments are linked as they are in the export, write the Markdown into another directory to decrypt them", logging.KeyPath, outputDir)
+	}
+}
+
+// sameDir reports whether both paths are the same directory.
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+
+	return absA == absB
+}
+
+// copyChannelAssets copies the downloaded files of the channel into output,
+// if assets are copied. Files that can't be copied are left out with a warning.
+func copyChannelAssets(channel *reader.Channel, output string) {
+	if !copyAssets {
+		return
+	}
+
+	for fileID := range channel.Data.Files {
+		p := channel.FilePath(fileID)
+		err := structs.CopyAsset(filepath.Join(output, filepath.FromSlash(p)), func() (io.ReadCloser, error) {
+			return channel.OpenFile(fileID)
+		})
+		if err != nil {
+			slog.Warn("Could not copy attachment", logging.KeyFile, fileID, logging.KeyPath, p, logging.Err(err))
+		}
+	}
+}
+
+// copyEmoji copies the custom emoji into output, if assets are copied
+// and the emoji are not there already.
+func copyEmoji(output string) {
+	if !copyAssets || slackEmoji == nil || sameDir(cfg.EmojiDir, filepath.Join(output, "emoji")) {
+		return
+	}
+
+	for name := range slackEmoji {
+		_, filename := slackEmoji.Get(name)
+		if filename == "" {
+			continue
+		}
+
+		err := structs.CopyAsset(filepath.Join(output, "emoji", filename), func() (io.ReadCloser, error) {
+			return os.Open(filepath.Join(cfg.EmojiDir, filename))
+		})
+		if err != nil {
+			slog.Warn("Could not copy emoji", "emoji", name, logging.Err(err))
+		}
+	}
+}
+
+// processExport converts all channels of the export, keeping the
+// workspace directories of Enterprise Grid exports, and writes the index.
+func processExport(export *reader.Export, output string) error {
+	if err := os.MkdirAll(output, 0o755); err != nil {
+		return fmt.Errorf("could not create output directory: %w", err)
+	}
+
+	var converted []*reader.Channel
+	count := 0
+
+	channels := export.Channels()
+	for channels.Next() {
+		channel := channels.Channel()
+		count++
+
+		target := filepath.Join(output, filepath.FromSlash(channel.Dir()))
+		if cfg.Split == splitChannel {
+			target = filepath.Join(target, channel.Data.Channel.ID+".md")
+		}
+
+		slog.Info("Processing file", logging.KeyPath, channel.Name)
+		if err := processChannel(channel, target); err != nil {
+			if errors.Is(err, errChannelIsArchived) {
+				slog.Info("Channel is archived, skipping", logging.KeyPath, channel.Name)
+				continue
+			}
+
+			if errors.Is(err, errNoMessages) {
+				slog.Info("No messages found, skipping", logging.KeyPath, channel.Name)
+				continue
+			}
+
+			return fmt.Errorf("could not process file %q: %w", channel.Name, err)
+		}
+
+		copyChannelAssets(channel, output)
+		converted = append(converted, channel)
+	}
+	if err := channels.Err(); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return fmt.Errorf("%w: %s", errNoChannels, cfg.Input)
+	}
+
+	slog.Info("Generating index", logging.KeyPath, output)
+	return generateIndex(output, converted)
+}
+
+// processChannel converts the channel to Markdown. With the channel split
+// output is the Markdown file, with the day split it's the directory
+// where the channel gets a subdirectory with a file per day.
+func processChannel(channel *reader.Channel, output string) error {
+	data := channel.Data
+	if data.Channel.IsArchived && cfg.SkipArchived {
+		return errChannelIsArchived
+	}
+
+	if len(data.Messages) == 0 {
+		return errNoMessages
+	}
+
+	// messages are exported newest first
+	slices.Reverse(data.Messages)
+
+	// links are relative to the output directory, which is the parent
+	// of the workspace directory in Enterprise Grid exports
+	root := ""
+	if channel.Dir() != "." {
+		root = strings.Repeat("../", strings.Count(channel.Dir(), "/")+1)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	if cfg.Split == splitChannel {
+		r := &renderer{data: data, resolver: channel.Resolver(), root: root, dir: channel.Dir()}
+		return writeFile(output, r.document(data.Messages, ""))
+	}
+
+	// the channel directory may already hold the attachments,
+	// so links from the day files are relative to the parent directory
+	r := &renderer{data: data, resolver: channel.Resolver(), root: "../" + root, dir: channel.Dir()}
+	dir := filepath.Join(output, data.Channel.ID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("could not create channel directory: %w", err)
+	}
+
+	for _, day := range splitByDay(data.Messages) {
+		filename := filepath.Join(dir, day.date+".md")
+		if err := writeFile(filename, r.document(day.messages, day.date)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type day struct {
+	date     string
+	messages []structs.Message
+}
+
+// splitByDay groups the messages by the day they were posted on;
+// replies stay with their thread parent.
+func splitByDay(messages []structs.Message) []day {
+	var days []day
+
+	for _, m := range messages {
+		date := messageTime(m.Timestamp).Format(time.DateOnly)
+		if len(days) == 0 || days[len(days)-1].date != date {
+			days = append(days, day{date: date})
+		}
+		days[len(days)-1].messages = append(days[len(days)-1].messages, m)
+	}
+
+	return days
+}
+
+func generateIndex(output string, channels []*reader.Channel) error {
+	sort.Slice(channels, func(i, j int) bool {
+		return title(channels[i].Data) < title(channels[j].Data)
+	})
+
+	sb := &strings.Builder{}
+	sb.WriteString("# Channels\n\n")
+
+	for _, c := range channels {
+		base := path.Join(c.Dir(), c.Data.Channel.ID)
+		if cfg.Split == splitChannel {
+			fmt.Fprintf(sb, "- [%s](%s.md)\n", escape(title(c.Data)), base)
+			continue
+		}
+
+		fmt.Fprintf(sb, "- %s\n", escape(title(c.Data)))
+		for _, day := range splitByDay(c.Data.Messages) {
+			fmt.Fprintf(sb, "  - [%s](%s/%s.md)\n", day.date, base, day.date)
+		}
+	}
+
+	return writeFile(filepath.Join(output, "index.md"), sb.String())
+}
+
+func writeFile(path, content string) error {
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("could not write file: %w", err)
+	}
+
+	return nil
+}
+
+func messageTime(ts string) time.Time {
+	t, err := structs.ParseTimestamp(ts)
+	if err != nil {
+		slog.Warn("Could not parse time", "ts", ts, logging.Err(err))
+	}
+
+	return t.In(location)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/enescakir/emoji"
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+var (
+	emojiSkinTone = regexp.MustCompile(`:skin-tone-(\d)`)
+
+	// markdownEscaper escapes the characters that would change the formatting
+	markdownEscaper = strings.NewReplacer(
+		`\`, `\\`,
+		"`", "\\`",
+		"*", `\*`,
+		"_", `\_`,
+		"~", `\~`,
+		"[", `\[`,
+		"]", `\]`,
+		"<", `\<`,
+		">", `\>`,
+		"#", `\#`,
+		"|", `\|`,
+	)
+)
+
+// renderer converts the messages of a channel to Markdown.
+// root is the path from the Markdown file to the output directory,
+// used to link attachments and custom emoji; dir is the directory
+// of the channel in the output directory, where its attachments are.
+type renderer struct {
+	data     *structs.Data
+	resolver structs.Resolver
+	root     string
+	dir      string
+}
+
+func escape(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+func title(d *structs.Data) string {
+	channel := d.Channel
+	switch {
+	case channel.IsIM:
+		return "👤 " + structs.ChannelName(channel, d.Users)[1:]
+	case channel.IsGroup, channel.IsMpIM:
+		return strings.Replace(channel.Purpose.Value, "Group messaging with: ", "👥 ", 1)
+	case channel.IsPrivate:
+		return "🔒 " + channel.Name
+	default:
+		return "#" + channel.Name
+	}
+}
+
+// document renders the messages with the channel name as the heading.
+func (r *renderer) document(messages []structs.Message, date string) string {
+	sb := &strings.Builder{}
+
+	heading := title(r.data)
+	if date != "" {
+		heading += " — " + date
+	}
+	fmt.Fprintf(sb, "# %s\n\n", escape(heading))
+
+	if topic := r.data.Channel.Topic.Value; topic != "" {
+		fmt.Fprintf(sb, "%s\n\n", escape(topic))
+	}
+
+	for i, m := range messages {
+		// consecutive messages of the same author share the header
+		if i == 0 || !messages[i-1].SameContext(m) || len(messages[i-1].Replies) > 0 {
+			sb.WriteString(r.header(m.Message))
+		}
+		sb.WriteString(r.message(m.Message))
+
+		// threads are rendered as quotes under the parent
+		replies := 0
+		for _, reply := range m.Replies {
+			if reply.Timestamp == m.Timestamp {
+				continue
+			}
+
+			if replies > 0 {
+				sb.WriteString(">\n")
+			}
+			sb.WriteString(quote(r.header(reply) + r.message(reply)))
+			replies++
+		}
+
+		if replies > 0 {
+			sb.WriteString("\n")
+		}
+	}
+
+	return sb.String()
+}
+
+func (r *renderer) header(m slack.Message) string {
+	author := cmp.Or(m.Username, m.BotID, "unknown")
+	if m.User != "" {
+		author = structs.UserName(r.data.Users[m.User])
+	}
+
+	edited := ""
+	if m.Edited != nil {
+		edited = " _(edited)_"
+	}
+
+	return fmt.Sprintf("**%s** · %s%s\n\n", escape(author), messageTime(m.Timestamp).Format("2006-01-02 15:04"), edited)
+}
+
+func (r *renderer) message(m slack.Message) string {
+	sb := &strings.Builder{}
+
+	text := ""
+	for _, block := range m.Blocks.BlockSet {
+		switch b := block.(type) {
+		case *slack.RichTextBlock:
+			text += r.richTextElements(b.Elements)
+		case *slack.SectionBlock:
+			if b.Text != nil {
+				text += escape(r.resolver.MrkdwnToPlainText(b.Text.Text)) + "\n\n"
+			}
+		}
+	}
+
+	if text == "" {
+		text = escape(r.resolver.MrkdwnToPlainText(m.Text))
+	}
+
+	if text = strings.TrimRight(text, "\n"); text != "" {
+		sb.WriteString(text)
+		sb.WriteString("\n\n")
+	}
+
+	for _, file := range m.Files {
+		sb.WriteString(r.attachment(file))
+		sb.WriteString("\n\n")
+	}
+
+	if len(m.Reactions) > 0 {
+		reactions := make([]string, 0, len(m.Reactions))
+		for _, reaction := range m.Reactions {
+			reactions = append(reactions, fmt.Sprintf("%s %d", r.emoji(reaction.Name), reaction.Count))
+		}
+		sb.WriteString(strings.Join(reactions, " · "))
+		sb.WriteString("\n\n")
+	}
+
+	return sb.String()
+}
+
+// attachment links the downloaded file, or the file in Slack if it wasn't downloaded.
+func (r *renderer) attachment(file slack.File) string {
+	label := escape(cmp.Or(file.Title, file.Name, file.ID))
+
+	filename, ok := r.data.Files[file.ID]
+	if !ok {
+		return fmt.Sprintf("[%s](%s)", label, cmp.Or(file.URLPrivateDownload, file.URLPrivate))
+	}
+
+	link := r.root + path.Join(r.dir, r.data.Channel.ID, url.PathEscape(file.ID+"-"+filename))
+
+	switch file.Filetype {
+	case "png", "jpg", "gif":
+		return fmt.Sprintf("![%s](%s)", label, link)
+	default:
+		return fmt.Sprintf("[%s](%s)", label, link)
+	}
+}
+
+func (r *renderer) richTextElements(elements []slack.RichTextElement) string {
+	sb := &strings.Builder{}
+
+	for _, element := range elements {
+		switch element.RichTextElementType() {
+		case slack.RTESection:
+			sb.WriteString(r.richTextSectionElements(element.(*slack.RichTextSection).Elements))
+			sb.WriteString("\n\n")
+		case slack.RTEQuote:
+			sb.WriteString(quote(r.richTextSectionElements(element.(*slack.RichTextQuote).Elements)))
+			sb.WriteString("\n")
+		case slack.RTEPreformatted:
+			sb.WriteString("```\n")
+			for _, e := range element.(*slack.RichTextPreformatted).Elements {
+				switch e.RichTextSectionElementType() {
+				case slack.RTSEText:
+					sb.WriteString(e.(*slack.RichTextSectionTextElement).Text)
+				case slack.RTSELink:
+					link := e.(*slack.RichTextSectionLinkElement)
+					sb.WriteString(cmp.Or(link.Text, link.URL))
+				}
+			}
+			sb.WriteString("\n```\n\n")
+		case slack.RTEList:
+			list := element.(*slack.RichTextList)
+			for i, item := range list.Elements {
+				sb.WriteString(strings.Repeat("    ", list.Indent))
+				if list.Style == slack.RTEListOrdered {
+					sb.WriteString(strconv.Itoa(i+1) + ". ")
+				} else {
+					sb.WriteString("- ")
+				}
+				if section, ok := item.(*slack.RichTextSection); ok {
+					sb.WriteString(r.richTextSectionElements(section.Elements))
+				}
+				sb.WriteString("\n")
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	return sb.String()
+}
+
+func (r *renderer) richTextSectionElements(elements []slack.RichTextSectionElement) string {
+	sb := &strings.Builder{}
+
+	for _, element := range elements {
+		switch element.RichTextSectionElementType() {
+		case slack.RTSEText:
+			te := element.(*slack.RichTextSectionTextElement)
+			sb.WriteString(styled(te.Text, te.Style))
+		case slack.RTSEUser:
+			user := r.data.Users[element.(*slack.RichTextSectionUserElement).UserID]
+			sb.WriteString("**@" + escape(structs.UserName(user)) + "**")
+		case slack.RTSEChannel:
+			id := element.(*slack.RichTextSectionChannelElement).ChannelID
+			sb.WriteString("#" + escape(cmp.Or(r.resolver.Channels[id], id)))
+		case slack.RTSEEmoji:
+			sb.WriteString(r.emoji(element.(*slack.RichTextSectionEmojiElement).Name))
+		case slack.RTSELink:
+			link := element.(*slack.RichTextSectionLinkElement)
+			fmt.Fprintf(sb, "[%s](%s)", escape(cmp.Or(link.Text, link.URL)), link.URL)
+		}
+	}
+
+	return sb.String()
+}
+
+// styled applies the text style, keeping the surrounding whitespace
+// outside of the markers as Markdown requires.
+func styled(text string, style *slack.RichTextSectionTextStyle) string {
+	if style == nil || strings.TrimSpace(text) == "" {
+		return strings.ReplaceAll(escape(text), "\n", "  \n")
+	}
+
+	trimmed := strings.TrimSpace(text)
+	start := strings.Index(text, trimmed)
+	leading, trailing := text[:start], text[start+len(trimmed):]
+
+	if style.Code {
+		return leading + "`" + trimmed + "`" + trailing
+	}
+
+	s := escape(trimmed)
+	if style.Bold {
+		s = "**" + s + "**"
+	}
+	if style.Italic {
+		s = "_" + s + "_"
+	}
+	if style.Strike {
+		s = "~~" + s + "~~"
+	}
+
+	return leading + s + trailing
+}
+
+func (r *renderer) emoji(name string) string {
+	if emojiSkinTone.MatchString(name) {
+		tone := emojiSkinTone.FindStringSubmatch(name)[1]
+		suffix := ""
+
+		switch tone {
+		case "2":
+			suffix = emoji.Light.String()
+		case "3":
+			suffix = emoji.MediumLight.String()
+		case "4":
+			suffix = emoji.Medium.String()
+		case "5":
+			suffix = emoji.MediumDark.String()
+		case "6":
+			suffix = emoji.Dark.String()
+		}
+
+		name = strings.Split(name, "::skin-tone-")[0]
+		return emoji.Parse(":"+name+":") + suffix
+	}
+
+	alias, filename := slackEmoji.Get(name)
+	if alias != "" {
+		return emoji.Parse(":" + alias + ":")
+	}
+
+	if filename != "" {
+		return fmt.Sprintf("![:%s:](%semoji/%s)", name, r.root, url.PathEscape(filename))
+	}
+
+	return emoji.Parse(":" + name + ":")
+}
+
+// quote prefixes every line with "> ".
+func quote(s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = ">"
+			continue
+		}
+		lines[i] = "> " + line
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
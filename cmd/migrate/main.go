@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/reader"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+type config struct {
+	Input      string `long:"input" short:"i" description:"Exported JSON file or directory to upgrade in place" required:"true"`
+	DryRun     bool   `long:"dry-run" description:"Only report which files would be upgraded"`
+	Passphrase string `env:"PASSPHRASE" long:"passphrase" description:"Passphrase of encrypted files; they stay encrypted"`
+	KeyFile    string `env:"KEY_FILE" long:"key-file" description:"Key file of encrypted files; they stay encrypted"`
+
+	Logging logging.Options `group:"Logging Options"`
+}
+
+var (
+	cfg    config
+	secret *structs.Secret
+
+	errBundle     = errors.New("bundles can't be upgraded in place, extract them first")
+	errNoChannels = errors.New("no exported channels found")
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("Could not migrate", logging.Err(err))
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if _, err := flags.Parse(&cfg); err != nil {
+		return fmt.Errorf("could not parse flags: %w", err)
+	}
+
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		return fmt.Errorf("could not set up logging: %w", err)
+	}
+
+	var err error
+	secret, err = structs.LoadSecret(cfg.Passphrase, cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load encryption secret: %w", err)
+	}
+
+	if structs.IsBundle(cfg.Input) {
+		return errBundle
+	}
+
+	info, err := os.Stat(cfg.Input)
+	if err != nil {
+		return fmt.Errorf("could not get file info: %w", err)
+	}
+
+	if !info.IsDir() {
+		return migrateFile(cfg.Input)
+	}
+
+	// lists the channels of Enterprise Grid workspaces too;
+	// the files are read below, so that their schema version is kept
+	export, err := reader.Open(cfg.Input)
+	if err != nil {
+		return err
+	}
+	defer export.Close()
+
+	names := export.Names()
+	if len(names) == 0 {
+		return fmt.Errorf("%w: %s", errNoChannels, cfg.Input)
+	}
+
+	for _, name := range names {
+		if err := migrateFile(filepath.Join(cfg.Input, filepath.FromSlash(name))); err != nil {
+			return fmt.Errorf("could not migrate file %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// migrateFile rewrites the file with the current schema if it is older,
+// keeping its compression and encryption.
+func migrateFile(path string) error {
+	encrypted, err := structs.IsEncrypted(path)
+	if err != nil {
+		return fmt.Errorf("could not read file: %w", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := structs.OpenData(f, secret)
+	if err != nil {
+		return err
+	}
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("could not read file: %w", err)
+	}
+
+	data, version, err := structs.DecodeData(content)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	// like the error report or the manifest
+	if data.Channel.ID == "" {
+		slog.Debug("Not an exported channel, skipping", logging.KeyPath, path)
+		return nil
+	}
+
+	if version == structs.SchemaVersion {
+		slog.Debug("File is up to date", logging.KeyPath, path, "schema_version", version)
+		return nil
+	}
+
+	slog.Info("Upgrading file", logging.KeyPath, path, "from", version, "to", structs.SchemaVersion)
+	if cfg.DryRun {
+		return nil
+	}
+
+	content, err = json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("could not marshal data: %w", err)
+	}
+
+	var writeSecret *structs.Secret
+	if encrypted {
+		writeSecret = secret
+	}
+
+	// write next to the file first, so that it's never left half-written;
+	// the name keeps the extension, which decides the compression
+	tmp := filepath.Join(filepath.Dir(path), ".migrate-"+filepath.Base(path))
+	if err := structs.WriteData(tmp, content, writeSecret); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("could not write file: %w", err)
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/reader"
+	"github.com/chuhlomin/slack-exporter/pkg/redact"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+type config struct {
+	Input      string   `long:"input" short:"i" description:"Input JSON file, directory or bundle (.zip or .tar.gz)" required:"true"`
+	Output     string   `long:"output" short:"o" description:"Output JSON file or directory" required:"true"`
+	Key        string   `env:"REDACT_KEY" long:"key" description:"Secret used to derive stable pseudonyms; random if empty"`
+	Patterns   []string `long:"pattern" description:"Regular expression to mask in message text (can be repeated)"`
+	Passphrase string   `env:"PASSPHRASE" long:"passphrase" description:"Passphrase to decrypt encrypted input files"`
+	KeyFile    string   `env:"KEY_FILE" long:"key-file" description:"Key file to decrypt encrypted input files"`
+
+	Logging logging.Options `group:"Logging Options"`
+}
+
+var (
+	cfg config
+
+	errNoChannels = errors.New("no exported channels found")
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("Could not redact", logging.Err(err))
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if _, err := flags.Parse(&cfg); err != nil {
+		return fmt.Errorf("could not parse flags: %w", err)
+	}
+
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		return fmt.Errorf("could not set up logging: %w", err)
+	}
+
+	r, err := redact.New(cfg.Key, cfg.Patterns)
+	if err != nil {
+		return fmt.Errorf("could not create redactor: %w", err)
+	}
+
+	secret, err := structs.LoadSecret(cfg.Passphrase, cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load encryption secret: %w", err)
+	}
+
+	info, err := os.Stat(cfg.Input)
+	if err != nil {
+		return fmt.Errorf("could not get file info: %w", err)
+	}
+
+	export, err := reader.Open(cfg.Input, reader.WithSecret(secret))
+	if err != nil {
+		return err
+	}
+	defer export.Close()
+
+	count := 0
+
+	channels := export.Channels()
+	for channels.Next() {
+		channel := channels.Channel()
+
+		output := cfg.Output
+		if info.IsDir() || structs.IsBundle(cfg.Input) {
+			// keeps the workspace directories of Enterprise Grid exports
+			output = filepath.Join(cfg.Output, filepath.FromSlash(channel.Name))
+		}
+
+		slog.Info("Processing file", logging.KeyPath, channel.Name)
+		if err := processChannel(r, channel.Data, output); err != nil {
+			return fmt.Errorf("could not process file %q: %w", channel.Name, err)
+		}
+		count++
+	}
+	if err := channels.Err(); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return fmt.Errorf("%w: %s", errNoChannels, cfg.Input)
+	}
+
+	if w := export.Workspaces(); w != nil {
+		return writeWorkspaces(w, cfg.Output)
+	}
+
+	return nil
+}
+
+// writeWorkspaces copies the workspace index of an Enterprise Grid export.
+func writeWorkspaces(w *structs.Workspaces, output string) error {
+	content, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal workspaces: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(output, structs.WorkspacesName), content, 0o600); err != nil {
+		return fmt.Errorf("could not write workspaces: %w", err)
+	}
+
+	return nil
+}
+
+// processChannel redacts the channel into output; the output is not encrypted,
+// as redacted exports are meant to be shared, but stays compressed.
+func processChannel(r *redact.Redactor, data *structs.Data, output string) error {
+	r.Data(data)
+
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return fmt.Errorf("could not create output directory: %w", err)
+	}
+
+	content, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("could not marshal messages: %w", err)
+	}
+
+	if err := structs.WriteData(output, content, nil); err != nil {
+		return fmt.Errorf("could not write file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/chuhlomin/slack-exporter/pkg/exporter"
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// daemonStatus is served as JSON on the status address in daemon mode.
+type daemonStatus struct {
+	mu sync.Mutex
+
+	Started time.Time  `json:"started"`
+	Runs    int        `json:"runs"`
+	Running *runResult `json:"running,omitempty"`
+	LastRun *runResult `json:"last_run,omitempty"`
+	NextRun *time.Time `json:"next_run,omitempty"`
+}
+
+// runResult describes a single export in daemon mode.
+type runResult struct {
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Channels int        `json:"channels"`
+	Failures int        `json:"failures"`
+	Error    string     `json:"error,omitempty"`
+}
+
+func (s *daemonStatus) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Running = &runResult{Started: time.Now()}
+	s.NextRun = nil
+}
+
+func (s *daemonStatus) finish(channels int, report *exporter.Report, err error, next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	finished := time.Now()
+	result := s.Running
+	result.Finished = &finished
+	result.Channels = channels
+	if report != nil {
+		result.Failures = len(report.Failures)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	s.Runs++
+	s.Running = nil
+	s.LastRun = result
+	s.NextRun = &next
+}
+
+// ServeHTTP responds with the status, and with 503 Service Unavailable
+// if the last run failed, for health checks.
+func (s *daemonStatus) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	content, err := json.MarshalIndent(s, "", "  ")
+	failed := s.LastRun != nil && s.LastRun.Error != ""
+	s.mu.Unlock()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if failed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(content)
+}
+
+// runDaemon exports the channels on the interval until interrupted,
+// reusing the exporter so that users and teams are looked up only once.
+// An interrupt stops it after the current run; a second one right away.
+func runDaemon(e *exporter.Exporter, secret *structs.Secret) error {
+	if cfg.Interval <= 0 {
+		return errBadInterval
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	status := &daemonStatus{Started: time.Now()}
+
+	if cfg.StatusAddress != "" {
+		listener, err := net.Listen("tcp", cfg.StatusAddress)
+		if err != nil {
+			return fmt.Errorf("could not listen on status address: %w", err)
+		}
+
+		server := &http.Server{Handler: status, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Status server failed", logging.Err(err))
+			}
+		}()
+		defer server.Close()
+
+		slog.Info("Serving status", "address", listener.Addr().String())
+	}
+
+	for {
+		status.start()
+		e.Reset()
+
+		channels, err := exportChannels(e, secret)
+		next := time.Now().Add(cfg.Interval)
+		status.finish(channels, e.Report(), err, next)
+
+		if err != nil {
+			slog.Error("Export failed", "next_run", next, logging.Err(err))
+		} else {
+			slog.Info("Export done", "channels", channels, "next_run", next)
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("Stopping")
+			return nil
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
@@ -1,26 +1,34 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
+	"cmp"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
-	"github.com/charmbracelet/bubbles/progress"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/chuhlomin/slack-exporter/pkg/exporter"
+	"github.com/chuhlomin/slack-exporter/pkg/htmlexport"
+	"github.com/chuhlomin/slack-exporter/pkg/httpclient"
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/recording"
+	"github.com/chuhlomin/slack-exporter/pkg/redact"
 	"github.com/chuhlomin/slack-exporter/pkg/structs"
 	"github.com/jessevdk/go-flags"
+	"github.com/slack-go/slack"
 )
 
 type config struct {
 	Channels        string `env:"CHANNELS" long:"channels" description:"Slack channel ID; pass \"public\" to export all public channels"`
+	Search          string `env:"SEARCH" long:"search" description:"Export the messages matching a search query instead of channels, like \"PROJ-1234 in:#general after:2024-01-01\""`
+	SearchThreads   bool   `env:"SEARCH_THREADS" long:"search-threads" description:"Export the whole thread of every search match"`
+	Workspaces      string `env:"WORKSPACES" long:"workspaces" description:"Enterprise Grid: export channels listed by type from each workspace into its own subdirectory; pass \"all\" or workspace IDs or domains, comma-separated"`
 	Output          string `env:"OUTPUT" long:"output" description:"Output directory" default:"output"`
 	APIToken        string `env:"API_TOKEN" long:"api-token" description:"Slack API Token"`
 	AppClientID     string `env:"APP_CLIENT_ID" long:"app-client-id" description:"Slack App Client ID"`
@@ -30,18 +38,63 @@ type config struct {
 	DownloadFiles   bool   `env:"DOWNLOAD_FILES" long:"download-files" description:"Download files"`
 	DownloadAvatars bool   `env:"DOWNLOAD_AVATARS" long:"download-avatars" description:"Download avatars"`
 	IncludeArchived bool   `env:"SKIP_ARCHIVED" long:"include-archived" description:"Include archived channels"`
+	KeepGoing       bool   `env:"KEEP_GOING" long:"keep-going" description:"Continue when a channel, thread or file fails and write an error report"`
+	ErrorReport     string `env:"ERROR_REPORT" long:"error-report" description:"Error report file name, relative to the output directory" default:"errors.json"`
+	Retry           string `env:"RETRY" long:"retry" description:"Re-attempt only the failed items from the given error report"`
+	Progress        string `env:"PROGRESS" long:"progress" description:"Progress view: interactive in a terminal, plain log lines otherwise" choice:"auto" choice:"tui" choice:"plain" default:"auto"`
+	DryRun          bool   `env:"DRY_RUN" long:"dry-run" description:"Print which channels would be exported and estimate the API calls, without fetching history"`
+	DryRunFormat    string `env:"DRY_RUN_FORMAT" long:"dry-run-format" description:"Dry run output format" choice:"table" choice:"json" default:"table"`
+
+	Threads []string `env:"THREADS" env-delim:"," long:"thread" description:"Export a single thread instead of channels, by message permalink or channel:ts (can be repeated)"`
+
+	Incremental   bool          `env:"INCREMENTAL" long:"incremental" description:"Fetch only the messages since the previous export of each channel"`
+	Lookback      time.Duration `env:"LOOKBACK" long:"lookback" description:"Fetch again the messages this long before the newest exported one, to pick up edits and replies" default:"24h"`
+	Daemon        bool          `env:"DAEMON" long:"daemon" description:"Keep running and export the channels incrementally on an interval"`
+	Interval      time.Duration `env:"INTERVAL" long:"interval" description:"Time between exports in daemon mode" default:"1h"`
+	StatusAddress string        `env:"STATUS_ADDRESS" long:"status-address" description:"Serve the daemon status as JSON on the address, like localhost:8081"`
+	HTMLOutput    string        `env:"HTML_OUTPUT" long:"html-output" description:"Regenerate the HTML archive in the directory after each export"`
+	HTMLEmoji     string        `env:"HTML_EMOJI" long:"html-emoji" description:"Directory with emoji for the HTML archive" default:"emoji"`
+
+	Redact         bool     `env:"REDACT" long:"redact" description:"Pseudonymize users and mask personal data before writing"`
+	RedactKey      string   `env:"REDACT_KEY" long:"redact-key" description:"Secret used to derive stable pseudonyms; random if empty"`
+	RedactPatterns []string `long:"redact-pattern" description:"Regular expression to mask in message text (can be repeated)"`
+
+	Passphrase string `env:"PASSPHRASE" long:"passphrase" description:"Encrypt output files with a key derived from the passphrase"`
+	KeyFile    string `env:"KEY_FILE" long:"key-file" description:"Encrypt output files with the key from the file (32 bytes or 64 hex characters)"`
+
+	Format   string `env:"FORMAT" long:"format" description:"Output format: one JSON document per channel, JSON Lines or CSV with one message per line" choice:"json" choice:"jsonl" choice:"csv" default:"json"`
+	Timezone string `env:"TIMEZONE" long:"timezone" description:"Time zone for the times in CSV output, like Europe/Berlin" default:"UTC"`
+	Compress bool   `env:"COMPRESS" long:"compress" description:"Write gzip-compressed .gz files"`
+	Bundle   string `env:"BUNDLE" long:"bundle" description:"Pack the output directory into a single archive next to it" choice:"zip" choice:"tar.gz"`
+
+	APIURL string `env:"SLACK_API_URL" long:"api-url" description:"Slack Web API base URL, like https://slack-gov.com/api/ for GovSlack" default:"https://slack.com/api/"`
+
+	Record string `env:"RECORD" long:"record" description:"Record Slack API responses and downloads to the directory, with tokens and emails scrubbed"`
+	Replay string `env:"REPLAY" long:"replay" description:"Replay Slack API responses and downloads recorded with --record, without network access"`
+
+	HTTP    httpclient.Options `group:"HTTP Options"`
+	Logging logging.Options    `group:"Logging Options"`
 }
 
 var (
 	cfg                         config
-	errBadStatus                = fmt.Errorf("bad status code")
 	errExpectedThreeInputs      = fmt.Errorf("expected three inputs")
 	errMissingClientIDAndSecret = fmt.Errorf("client ID and secret are required")
+	errInterrupted              = fmt.Errorf("interrupted")
+	errRecordAndReplay          = fmt.Errorf("--record and --replay can't be used together")
+	errUnknownWorkspace         = fmt.Errorf("workspace not found")
+	errDryRunNotSupported       = fmt.Errorf("--dry-run is not supported with --retry, --search or --thread")
+	errDaemonNotSupported       = fmt.Errorf("--daemon is not supported with --retry, --search, --thread or --dry-run")
+	errBadInterval              = fmt.Errorf("--interval must be positive")
+
+	// onProgress receives export progress while withProgress runs
+	onProgress func(tea.Msg)
 )
 
 func main() {
 	if err := run(); err != nil {
-		log.Fatalf("Error: %v", err)
+		slog.Error("Export failed", logging.Err(err))
+		os.Exit(1)
 	}
 }
 
@@ -50,7 +103,33 @@ func run() error {
 		return fmt.Errorf("could not parse flags: %w", err)
 	}
 
-	if cfg.AppClientID == "" || cfg.AppClientSecret == "" {
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		return fmt.Errorf("could not set up logging: %w", err)
+	}
+
+	if cfg.Record != "" && cfg.Replay != "" {
+		return errRecordAndReplay
+	}
+
+	if cfg.DryRun && (cfg.Retry != "" || cfg.Search != "" || len(cfg.Threads) > 0) {
+		return errDryRunNotSupported
+	}
+
+	if cfg.Daemon {
+		if cfg.Retry != "" || cfg.Search != "" || len(cfg.Threads) > 0 || cfg.DryRun {
+			return errDaemonNotSupported
+		}
+
+		// nobody watches a daemon's terminal
+		cfg.Progress = "plain"
+	}
+
+	// replayed sessions don't need real credentials
+	if cfg.Replay != "" && cfg.APIToken == "" {
+		cfg.APIToken = "xoxp-replay"
+	}
+
+	if cfg.Replay == "" && (cfg.AppClientID == "" || cfg.AppClientSecret == "") {
 		model := initialModelInputs(cfg.AppClientID, cfg.AppClientSecret)
 		if _, err := tea.NewProgram(model).Run(); err != nil {
 			return fmt.Errorf("could not get inputs: %w", err)
@@ -69,20 +148,93 @@ func run() error {
 		}
 	}
 
-	c := NewSlackClient(cfg.AppClientID, cfg.AppClientSecret)
+	secret, err := structs.LoadSecret(cfg.Passphrase, cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load encryption secret: %w", err)
+	}
+
+	// the API client expects the trailing slash
+	if !strings.HasSuffix(cfg.APIURL, "/") {
+		cfg.APIURL += "/"
+	}
+
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		return err
+	}
 
-	if cfg.APIToken == "" {
-		err := getToken(c)
+	token := cfg.APIToken
+	if token == "" {
+		token, err = getToken(httpClient)
 		if err != nil {
 			return fmt.Errorf("could not get token: %w", err)
 		}
-	} else {
-		c.SetToken(cfg.APIToken)
 	}
 
 	// make sure the output directory exists
-	if err := os.MkdirAll(cfg.Output, 0o755); err != nil {
-		return fmt.Errorf("could not create output directory: %w", err)
+	if !cfg.DryRun {
+		if err := os.MkdirAll(cfg.Output, 0o755); err != nil {
+			return fmt.Errorf("could not create output directory: %w", err)
+		}
+	}
+
+	var redactor *redact.Redactor
+	if cfg.Redact {
+		redactor, err = redact.New(cfg.RedactKey, cfg.RedactPatterns)
+		if err != nil {
+			return fmt.Errorf("could not create redactor: %w", err)
+		}
+
+		if cfg.DownloadFiles || cfg.DownloadAvatars {
+			slog.Warn("Files and avatars are not downloaded when redacting")
+			cfg.DownloadFiles = false
+			cfg.DownloadAvatars = false
+		}
+	}
+
+	if cfg.Retry != "" {
+		e, err := newExporter(token, httpClient, secret, redactor)
+		if err != nil {
+			return err
+		}
+
+		previous, err := exporter.ReadReport(cfg.Retry)
+		if err != nil {
+			return err
+		}
+
+		if err := e.Retry(previous); err != nil {
+			return err
+		}
+		return e.Finish()
+	}
+
+	if cfg.Search != "" || len(cfg.Threads) > 0 {
+		var refs []exporter.ThreadRef
+		for _, thread := range cfg.Threads {
+			ref, err := exporter.ParseThreadRef(thread)
+			if err != nil {
+				return err
+			}
+			refs = append(refs, ref)
+		}
+
+		e, err := newExporter(token, httpClient, secret, redactor)
+		if err != nil {
+			return err
+		}
+
+		err = withProgress(func() error {
+			if len(refs) > 0 {
+				return e.ExportThreads(refs)
+			}
+			return e.ExportSearch(cfg.Search, cfg.SearchThreads)
+		})
+		if err != nil {
+			return err
+		}
+
+		return e.Finish()
 	}
 
 	if cfg.Channels == "" {
@@ -100,10 +252,11 @@ func run() error {
 			if model.choices[i].isChannel {
 				cfg.Channels += model.choices[i].value + ","
 			}
-			if i == downloadAvatarsIndex {
+			// files and avatars are not downloaded when redacting, see above
+			if i == downloadAvatarsIndex && redactor == nil {
 				cfg.DownloadAvatars = true
 			}
-			if i == downloadFilesIndex {
+			if i == downloadFilesIndex && redactor == nil {
 				cfg.DownloadFiles = true
 			}
 			if i == includeArchivedIndex {
@@ -127,218 +280,280 @@ func run() error {
 		}
 	}
 
-	channels := strings.Split(cfg.Channels, ",")
+	e, err := newExporter(token, httpClient, secret, redactor)
+	if err != nil {
+		return err
+	}
+
+	if cfg.DryRun {
+		targets, err := channelTargets(e)
+		if err != nil {
+			return err
+		}
+		return makePlan(e, targets).write(os.Stdout, cfg.DryRunFormat)
+	}
 
-	var channelTypes []string
-	for _, channel := range channels {
+	if cfg.Daemon {
+		return runDaemon(e, secret)
+	}
+
+	_, err = exportChannels(e, secret)
+	return err
+}
+
+// channelTargets returns the channels to export:
+// the ones given by ID and the ones listed by type.
+func channelTargets(e *exporter.Exporter) ([]exporter.Target, error) {
+	var (
+		targets      []exporter.Target
+		channelTypes []string
+	)
+	for _, channel := range strings.Split(cfg.Channels, ",") {
 		switch channel {
 		case "public_channel", "private_channel", "mpim", "im":
 			channelTypes = append(channelTypes, channel)
 		case "":
 			continue
 		default:
-			err := exportChannel(c, channel)
-			if err != nil {
-				return fmt.Errorf("could not export channel %q: %w", channel, err)
-			}
+			targets = append(targets, exporter.Target{ID: channel, Name: channel})
 		}
 	}
 
-	if len(channelTypes) > 0 {
-		err := exportChannels(c, channelTypes)
+	switch {
+	case len(channelTypes) > 0 && cfg.Workspaces != "":
+		teams, err := selectWorkspaces(e, cfg.Workspaces)
 		if err != nil {
-			return fmt.Errorf("could not export channels: %w", err)
+			return nil, err
 		}
-	}
 
-	if cfg.DownloadAvatars {
-		log.Println("Downloading avatars")
-		if err := downloadAvatars(c); err != nil {
-			return fmt.Errorf("could not download avatars: %w", err)
+		list, err := e.WorkspaceTargets(teams, channelTypes)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, list...)
+	case len(channelTypes) > 0:
+		list, err := e.Channels(channelTypes)
+		if err != nil {
+			return nil, fmt.Errorf("could not get channels: %w", err)
+		}
+
+		for i, channel := range list {
+			targets = append(targets, exporter.Target{ID: channel.ID, Name: cmp.Or(channel.Name, channel.ID), Info: &list[i]})
 		}
 	}
 
-	return nil
+	return targets, nil
 }
 
-func getToken(c *SlackClient) error {
-	state := RandStringBytesMaskImprSrcSB(16)
-	authorizeURL := c.GetAuthorizeURL(state)
-
-	if err := openBrowser(authorizeURL); err != nil {
-		log.Printf("App authorization URL: %s", authorizeURL)
+// exportChannels exports the channels and regenerates the HTML archive,
+// if requested, also when some items failed.
+// It returns the number of channels.
+func exportChannels(e *exporter.Exporter, secret *structs.Secret) (int, error) {
+	targets, err := channelTargets(e)
+	if err != nil {
+		return 0, err
 	}
 
-	model := initialModelCode()
-	updatedModel, err := tea.NewProgram(model).Run()
+	err = withProgress(func() error {
+		return e.Export(targets)
+	})
 	if err != nil {
-		return err
+		return len(targets), err
 	}
 
-	code := strings.TrimSpace(updatedModel.(modelCode).code.Value())
-	if err := c.GetToken(code); err != nil {
-		return err
+	err = e.Finish()
+	if err != nil && !errors.Is(err, exporter.ErrIncompleteExport) {
+		return len(targets), err
+	}
+
+	if cfg.HTMLOutput != "" {
+		slog.Info("Generating HTML archive", logging.KeyPath, cfg.HTMLOutput)
+		htmlErr := htmlexport.New(htmlexport.Options{
+			EmojiDir: cfg.HTMLEmoji,
+			Secret:   secret,
+		}).Convert(cfg.Output, cfg.HTMLOutput)
+		if htmlErr != nil {
+			return len(targets), fmt.Errorf("could not generate HTML archive: %w", htmlErr)
+		}
 	}
 
-	return nil
+	return len(targets), err
 }
 
-func exportChannel(c *SlackClient, channelID string) error {
-	channelInfo, err := c.GetChannelInfo(channelID)
+// selectWorkspaces returns the Enterprise Grid workspaces to export:
+// all the token can access, or the ones given by ID or domain.
+func selectWorkspaces(e *exporter.Exporter, selection string) ([]slack.Team, error) {
+	teams, err := e.ListWorkspaces()
 	if err != nil {
-		return fmt.Errorf("could not get channel %q info: %w", channelID, err)
+		return nil, err
 	}
 
-	if channelInfo.IsArchived && !cfg.IncludeArchived {
-		return nil
+	if selection == "all" {
+		return teams, nil
 	}
 
-	outputFilename := filepath.Join(cfg.Output, channelID+".json")
-
-	// check if the file already exists
-	if _, err := os.Stat(outputFilename); err == nil {
-		// read the file to pull users
-		data, err := os.ReadFile(outputFilename)
-		if err != nil {
-			return fmt.Errorf("could not read file: %w", err)
+	var selected []slack.Team
+	for _, name := range strings.Split(selection, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
 		}
 
-		var d structs.Data
-		if err = json.Unmarshal(data, &d); err != nil {
-			return fmt.Errorf("could not unmarshal data: %w", err)
+		found := false
+		for _, team := range teams {
+			if team.ID == name || team.Domain == name {
+				selected = append(selected, team)
+				found = true
+				break
+			}
 		}
 
-		for id, user := range d.Users {
-			c.UsersCache[id] = user
+		if !found {
+			return nil, fmt.Errorf("%w: %q", errUnknownWorkspace, name)
 		}
 	}
 
-	msgs, err := c.GetMessages(channelID)
-	if err != nil {
-		return fmt.Errorf("could not get messages: %w", err)
+	return selected, nil
+}
+
+// newExporter creates the exporter with the options from the flags.
+func newExporter(
+	token string,
+	httpClient *http.Client,
+	secret *structs.Secret,
+	redactor *redact.Redactor,
+) (*exporter.Exporter, error) {
+	opts := exporter.Options{
+		Output:          cfg.Output,
+		Format:          cfg.Format,
+		Compress:        cfg.Compress,
+		Bundle:          cfg.Bundle,
+		Secret:          secret,
+		Redactor:        redactor,
+		DownloadFiles:   cfg.DownloadFiles,
+		DownloadAvatars: cfg.DownloadAvatars,
+		IncludeArchived: cfg.IncludeArchived,
+		Incremental:     cfg.Incremental || cfg.Daemon,
+		Lookback:        cfg.Lookback,
+		KeepGoing:       cfg.KeepGoing,
+		ErrorReport:     cfg.ErrorReport,
+		HTTPClient:      httpClient,
+		NoRateLimit:     cfg.Replay != "",
+		OnProgress: func(event exporter.Event) {
+			if onProgress != nil {
+				onProgress(event)
+			}
+		},
 	}
 
-	var files map[string]string
-	if cfg.DownloadFiles {
-		files, err = c.DownloadFiles(channelID)
+	if cfg.Format == exporter.FormatCSV {
+		location, err := time.LoadLocation(cfg.Timezone)
 		if err != nil {
-			return fmt.Errorf("could not download files: %w", err)
+			return nil, fmt.Errorf("could not load time zone %q: %w", cfg.Timezone, err)
 		}
+		opts.Location = location
 	}
 
-	users, err := c.GetUsers()
-	if err != nil {
-		return fmt.Errorf("could not get users: %w", err)
-	}
+	// the API client shares the settings and transport of the downloads,
+	// and picks up profiles of external users from the messages
+	opts.Profiles = exporter.NewProfileCollector(httpClient.Transport)
+	apiClient := *httpClient
+	apiClient.Transport = opts.Profiles
 
-	data := structs.Data{
-		Channel:  *channelInfo,
-		Messages: msgs,
-		Users:    users,
-		Files:    files,
-	}
+	api := slack.New(token, slack.OptionHTTPClient(&apiClient), slack.OptionAPIURL(cfg.APIURL))
+	return exporter.New(api, token, opts), nil
+}
 
-	// Save to a file
-	content, err := json.Marshal(data)
+// newHTTPClient returns the client shared by the Slack API and downloads,
+// recording or replaying the traffic if requested.
+func newHTTPClient() (*http.Client, error) {
+	client, err := httpclient.New(cfg.HTTP)
 	if err != nil {
-		return fmt.Errorf("could not marshal messages: %w", err)
-	}
-
-	if err = os.WriteFile(outputFilename, content, 0o600); err != nil {
-		return fmt.Errorf("could not write messages to file: %w", err)
+		return nil, fmt.Errorf("could not create HTTP client: %w", err)
 	}
 
-	return nil
-}
-
-func exportChannels(c *SlackClient, types []string) error {
-	channels, err := c.GetChannels(types)
-	if err != nil {
-		return fmt.Errorf("could not get public channels: %w", err)
-	}
-
-	prog := progress.New(progress.WithScaledGradient("#FF7CCB", "#FDFF8C"))
-	fmt.Print(prog.ViewAs(0))
-	previousName := ""
-
-	for i, channel := range channels {
-		fmt.Printf(
-			"\r%s (%d/%d) %s%s%s",
-			prog.ViewAs(float64(i+1)/float64(len(channels))),
-			i+1,
-			len(channels),
-			channel.Name,
-			strings.Repeat(" ", max(0, len(previousName)-len(channel.Name))),
-			strings.Repeat("\b", max(0, len(previousName)-len(channel.Name))),
-		)
-		err := exportChannel(c, channel.ID)
+	switch {
+	case cfg.Record != "":
+		recorder, err := recording.NewRecorder(cfg.Record, client.Transport)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Recording Slack API traffic", logging.KeyPath, cfg.Record)
+		client.Transport = recorder
+	case cfg.Replay != "":
+		replayer, err := recording.NewReplayer(cfg.Replay)
 		if err != nil {
-			return fmt.Errorf("could not export channel %q: %w", channel.Name, err)
+			return nil, err
 		}
-		previousName = channel.Name
+		slog.Info("Replaying Slack API traffic", logging.KeyPath, cfg.Replay)
+		client.Transport = replayer
 	}
 
-	fmt.Printf(
-		"\r%s (%d/%d) %s%s",
-		prog.ViewAs(1),
-		len(channels),
-		len(channels),
-		strings.Repeat(" ", len(previousName)),
-		strings.Repeat("\b", len(previousName)),
-	)
-
-	return nil
+	return client, nil
 }
 
-func downloadAvatars(c *SlackClient) error {
-	err := os.MkdirAll(filepath.Join(cfg.Output, "avatars"), 0o755)
+func getToken(client *http.Client) (string, error) {
+	state := RandStringBytesMaskImprSrcSB(16)
+	authURL, err := authorizeURL(cfg.APIURL, cfg.AppClientID, state)
 	if err != nil {
-		return fmt.Errorf("could not create avatars directory: %w", err)
+		return "", err
 	}
 
-	for _, user := range c.UsersCache {
-		if user.Profile.Image512 != "" {
-			err := downloadFile(user.ID, user.Profile.Image512, cfg.Output)
-			if err != nil {
-				return fmt.Errorf("could not download avatar: %w", err)
-			}
-		}
+	if err := openBrowser(authURL); err != nil {
+		slog.Info("Open the app authorization URL in a browser", "url", authURL)
 	}
 
-	return nil
-}
-
-func downloadFile(id, fileURL, output string) error {
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, fileURL, http.NoBody)
+	model := initialModelCode()
+	updatedModel, err := tea.NewProgram(model).Run()
 	if err != nil {
-		return fmt.Errorf("could not create request: %w", err)
+		return "", err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("could not send request: %w", err)
+	code := strings.TrimSpace(updatedModel.(modelCode).code.Value())
+	return requestToken(client, cfg.APIURL, cfg.AppClientID, cfg.AppClientSecret, code)
+}
+
+// withProgress runs the export while showing its progress:
+// as a bubbletea program in a terminal, or as plain log lines otherwise.
+func withProgress(export func() error) error {
+	defer func() { onProgress = nil }()
+
+	if cfg.Progress == "plain" || (cfg.Progress == "auto" && !isTerminal(os.Stdout)) {
+		pp := &plainProgress{}
+		onProgress = pp.send
+		return export()
 	}
 
-	defer resp.Body.Close()
+	model := initialModelProgress()
+	p := tea.NewProgram(model)
+	onProgress = p.Send
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("%w: %d", errBadStatus, resp.StatusCode)
+	// logs would break the terminal UI, show them in the log pane instead
+	if err := logging.Setup(logPane{send: p.Send}, cfg.Logging); err != nil {
+		return fmt.Errorf("could not set up logging: %w", err)
 	}
+	defer func() {
+		_ = logging.Setup(os.Stderr, cfg.Logging)
+	}()
 
-	filename := filepath.Join(output, "avatars", id+".png")
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("could not create file: %w", err)
+	go func() {
+		p.Send(progressDoneMsg{err: export()})
+	}()
+
+	if _, err := p.Run(); err != nil {
+		return fmt.Errorf("could not show progress: %w", err)
 	}
 
-	defer file.Close()
+	return model.err
+}
 
-	_, err = io.Copy(file, resp.Body)
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
 	if err != nil {
-		return fmt.Errorf("could not write file: %w", err)
+		return false
 	}
 
-	return nil
+	return info.Mode()&os.ModeCharDevice != 0
 }
 
 func openBrowser(someURL string) error {
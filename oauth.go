@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	errInvalidTokenResponse = fmt.Errorf("invalid token response")
+	errCodeRequired         = fmt.Errorf("argument 'code' is required")
+)
+
+// TokenResponse represents the response from the Slack API when requesting a token.
+// Only Ok and AuthedUser.AccessToken are used.
+type TokenResponse struct {
+	Ok          bool   `json:"ok"`
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	Scope       string `json:"scope"`
+	BotUserID   string `json:"bot_user_id"`
+	AppID       string `json:"app_id"`
+	Team        struct {
+		Name string `json:"name"`
+		ID   string `json:"id"`
+	} `json:"team"`
+	Enterprise struct {
+		Name string `json:"name"`
+		ID   string `json:"id"`
+	} `json:"enterprise"`
+	AuthedUser struct {
+		ID          string `json:"id"`
+		Scope       string `json:"scope"`
+		TokenType   string `json:"token_type"`
+		AccessToken string `json:"access_token"`
+	} `json:"authed_user"`
+}
+
+// authorizeURL returns the URL to authorize the app and start the OAuth flow.
+// It is on the same host as the API, like slack.com or slack-gov.com.
+func authorizeURL(apiURL, clientID, state string) (string, error) {
+	api, err := url.Parse(apiURL)
+	if err != nil {
+		return "", fmt.Errorf("could not parse API URL: %w", err)
+	}
+
+	result := url.URL{
+		Scheme: api.Scheme,
+		Host:   api.Host,
+		Path:   "/oauth/v2/authorize",
+	}
+
+	vals := result.Query()
+	vals.Add("scope", "")
+	vals.Add("user_scope", strings.Join(
+		[]string{
+			"users:read",
+			"files:read",
+			"emoji:read",
+			"channels:read",
+			"channels:history",
+			"groups:read",
+			"groups:history",
+			"im:read",
+			"im:history",
+			"mpim:read",
+			"mpim:history",
+		},
+		",",
+	))
+	vals.Add("redirect_uri", "https://oauth-redirect.pages.dev")
+	vals.Add("client_id", clientID)
+
+	if state != "" {
+		vals.Add("state", state)
+	}
+
+	result.RawQuery = vals.Encode()
+
+	return result.String(), nil
+}
+
+// requestToken exchanges the code from the OAuth flow for a user token.
+func requestToken(client *http.Client, apiURL, clientID, clientSecret, code string) (string, error) {
+	if code == "" {
+		return "", errCodeRequired
+	}
+
+	// set multipart/form-data values
+	multipartData := &bytes.Buffer{}
+	writer := multipart.NewWriter(multipartData)
+	if err := writer.WriteField("client_id", clientID); err != nil {
+		return "", fmt.Errorf("could not write field: %w", err)
+	}
+	if err := writer.WriteField("client_secret", clientSecret); err != nil {
+		return "", fmt.Errorf("could not write field: %w", err)
+	}
+	if err := writer.WriteField("code", code); err != nil {
+		return "", fmt.Errorf("could not write field: %w", err)
+	}
+	writer.Close()
+
+	req, err := http.NewRequestWithContext(
+		context.Background(),
+		http.MethodPost,
+		apiURL+"oauth.v2.access",
+		multipartData,
+	)
+	if err != nil {
+		return "", fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not send request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("could not read response: %w", err)
+	}
+
+	var token TokenResponse
+	if err := json.Unmarshal(b, &token); err != nil {
+		return "", fmt.Errorf("could not decode response: %w", err)
+	}
+
+	if !token.Ok {
+		return "", fmt.Errorf("%w: %v", errInvalidTokenResponse, string(b))
+	}
+
+	return token.AuthedUser.AccessToken, nil
+}
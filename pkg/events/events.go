@@ -0,0 +1,250 @@
+// Package events receives Slack Events API callbacks and keeps the
+// message and reaction events in a log per channel, which Apply merges
+// into exported channels. Unlike history, the log keeps messages that
+// are deleted before the next export, and every edit.
+package events
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+)
+
+// LogExt is the extension of the channel logs, named after the channel ID.
+const LogExt = ".jsonl"
+
+// maxBodySize is the largest request body accepted, well above Slack's events.
+const maxBodySize = 1 << 20
+
+// Event types written to the logs; message subtypes
+// like message_changed and message_deleted are message events.
+const (
+	TypeMessage         = "message"
+	TypeReactionAdded   = "reaction_added"
+	TypeReactionRemoved = "reaction_removed"
+)
+
+var (
+	channelID = regexp.MustCompile(`^[A-Z0-9]+$`)
+
+	errNoChannel = errors.New("event has no channel")
+)
+
+// Entry is a single event in a channel log, as Slack sent it.
+type Entry struct {
+	Received time.Time       `json:"received"`
+	EventID  string          `json:"event_id,omitempty"`
+	Event    json.RawMessage `json:"event"`
+}
+
+// envelope is the outer callback, see https://api.slack.com/apis/events-api#callback-field
+type envelope struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	EventID   string          `json:"event_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// event holds the fields of the logged events that Apply needs,
+// besides the message itself.
+type event struct {
+	Type             string     `json:"type"`
+	Subtype          string     `json:"subtype"`
+	Channel          string     `json:"channel"`
+	User             string     `json:"user"`
+	Reaction         string     `json:"reaction"`
+	DeletedTimestamp string     `json:"deleted_ts"`
+	Message          *slack.Msg `json:"message"`
+	PreviousMessage  *slack.Msg `json:"previous_message"`
+	Item             struct {
+		Type      string `json:"type"`
+		Channel   string `json:"channel"`
+		Timestamp string `json:"ts"`
+	} `json:"item"`
+}
+
+// channel returns the channel the event happened in.
+func (ev *event) channel() string {
+	if ev.Channel != "" {
+		return ev.Channel
+	}
+
+	return ev.Item.Channel
+}
+
+// Receiver is an http.Handler for the Events API request URL.
+// It verifies the signing secret, answers the URL verification
+// challenge, and appends message and reaction events to the log
+// of their channel in the directory. Other events are acknowledged
+// and dropped.
+type Receiver struct {
+	dir    string
+	secret string
+
+	mu sync.Mutex
+}
+
+// NewReceiver creates a Receiver writing the logs to the directory.
+func NewReceiver(dir, signingSecret string) *Receiver {
+	return &Receiver{dir: dir, secret: signingSecret}
+}
+
+// ServeHTTP handles a single callback.
+func (rc *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "could not read body", http.StatusBadRequest)
+		return
+	}
+
+	verifier, err := slack.NewSecretsVerifier(r.Header, rc.secret)
+	if err == nil {
+		_, _ = verifier.Write(body)
+		err = verifier.Ensure()
+	}
+	if err != nil {
+		// the error holds the expected signature, which must not leak
+		slog.Warn("Rejected event with a bad signature", "remote", r.RemoteAddr)
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+
+	var env envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		http.Error(w, "could not decode body", http.StatusBadRequest)
+		return
+	}
+
+	switch env.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, env.Challenge)
+		return
+	case "event_callback":
+	default:
+		slog.Debug("Ignoring callback", "type", env.Type)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := rc.append(env); err != nil {
+		slog.Error("Could not log event", "event_id", env.EventID, logging.Err(err))
+		// Slack retries failed deliveries
+		http.Error(w, "could not log event", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// append writes the event to the log of its channel,
+// if it is a message or reaction event.
+func (rc *Receiver) append(env envelope) error {
+	var ev event
+	if err := json.Unmarshal(env.Event, &ev); err != nil {
+		return fmt.Errorf("could not decode event: %w", err)
+	}
+
+	switch ev.Type {
+	case TypeMessage, TypeReactionAdded, TypeReactionRemoved:
+	default:
+		slog.Debug("Ignoring event", "type", ev.Type, "event_id", env.EventID)
+		return nil
+	}
+
+	channel := ev.channel()
+	if !channelID.MatchString(channel) {
+		return fmt.Errorf("%w: %s", errNoChannel, env.EventID)
+	}
+
+	line, err := json.Marshal(Entry{Received: time.Now().UTC(), EventID: env.EventID, Event: env.Event})
+	if err != nil {
+		return fmt.Errorf("could not marshal entry: %w", err)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	path := filepath.Join(rc.dir, channel+LogExt)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("could not write log: %w", err)
+	}
+
+	slog.Debug("Logged event", logging.KeyChannel, channel, "type", ev.Type, "subtype", ev.Subtype, "event_id", env.EventID)
+
+	return f.Close()
+}
+
+// ReadLog reads the entries of a channel log, oldest first.
+func ReadLog(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBodySize+1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("could not decode log line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read log: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Sign returns the headers Slack signs a request body with,
+// to send sample events to a local Receiver.
+func Sign(signingSecret string, now time.Time, body []byte) http.Header {
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	_, _ = mac.Write([]byte("v0:" + ts + ":"))
+	_, _ = mac.Write(body)
+
+	header := http.Header{}
+	header.Set("X-Slack-Request-Timestamp", ts)
+	header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	header.Set("Content-Type", "application/json")
+	return header
+}
@@ -0,0 +1,210 @@
+package events
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// Apply merges the entries of a channel log into the channel, oldest first:
+// new messages and replies are added, edited messages replaced with
+// their earlier versions kept in the history, deleted messages marked
+// deleted, and reactions updated. Applying the same entries again
+// changes nothing, so the whole log can be applied after every export.
+// Authors of new messages are only in Users after the next export.
+func Apply(d *structs.Data, entries []Entry) error {
+	for _, entry := range entries {
+		var ev event
+		if err := json.Unmarshal(entry.Event, &ev); err != nil {
+			return fmt.Errorf("could not decode event %s: %w", entry.EventID, err)
+		}
+
+		switch ev.Type {
+		case TypeMessage:
+			if err := applyMessage(d, ev, entry); err != nil {
+				return fmt.Errorf("could not apply event %s: %w", entry.EventID, err)
+			}
+		case TypeReactionAdded:
+			if msg := find(d, ev.Item.Timestamp); msg != nil {
+				addReaction(msg, ev.Reaction, ev.User)
+			}
+		case TypeReactionRemoved:
+			if msg := find(d, ev.Item.Timestamp); msg != nil {
+				removeReaction(msg, ev.Reaction, ev.User)
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyMessage applies a message event by its subtype.
+func applyMessage(d *structs.Data, ev event, entry Entry) error {
+	switch ev.Subtype {
+	case "message_changed", "message_replied":
+		if ev.Message == nil {
+			return nil
+		}
+
+		changed := *ev.Message
+		changed.Channel = cmp.Or(changed.Channel, ev.Channel)
+		if msg := find(d, changed.Timestamp); msg != nil {
+			// applied before, or a later export has a newer version;
+			// events without an edit, like unfurls and reply counts,
+			// would bring back the text from before the edit
+			current := structs.EditedTimestamp(msg.Msg)
+			if current != "" && structs.EditedTimestamp(changed) <= current {
+				return nil
+			}
+
+			if structs.IsEdit(msg.Msg, changed) {
+				d.AddVersion(msg.Msg, entry.Received)
+			}
+			keepThread(&changed, msg.Msg)
+			msg.Msg = changed
+			return nil
+		}
+
+		// edited before it was logged or exported
+		insert(d, slack.Message{Msg: changed})
+	case "message_deleted":
+		// kept as it was last seen, or as the event remembers it
+		if find(d, ev.DeletedTimestamp) == nil {
+			if ev.PreviousMessage == nil {
+				return nil
+			}
+			previous := *ev.PreviousMessage
+			previous.Channel = cmp.Or(previous.Channel, ev.Channel)
+			insert(d, slack.Message{Msg: previous})
+		}
+		d.MarkDeleted(ev.DeletedTimestamp, entry.Received)
+	default:
+		var msg slack.Message
+		if err := json.Unmarshal(entry.Event, &msg); err != nil {
+			return fmt.Errorf("could not decode message: %w", err)
+		}
+		insert(d, msg)
+	}
+
+	return nil
+}
+
+// keepThread keeps the replies and reactions of the message,
+// which the changed message may leave out; their own events update them.
+func keepThread(changed *slack.Msg, previous slack.Msg) {
+	if changed.ReplyCount == 0 {
+		changed.ReplyCount = previous.ReplyCount
+		changed.LatestReply = previous.LatestReply
+		changed.ReplyUsers = previous.ReplyUsers
+	}
+	if changed.ThreadTimestamp == "" {
+		changed.ThreadTimestamp = previous.ThreadTimestamp
+	}
+	if changed.Reactions == nil {
+		changed.Reactions = previous.Reactions
+	}
+}
+
+// find returns the message or reply with the timestamp, or nil.
+func find(d *structs.Data, ts string) *slack.Message {
+	for i := range d.Messages {
+		if d.Messages[i].Timestamp == ts {
+			return &d.Messages[i].Message
+		}
+
+		for j := range d.Messages[i].Replies {
+			if d.Messages[i].Replies[j].Timestamp == ts {
+				return &d.Messages[i].Replies[j]
+			}
+		}
+	}
+
+	return nil
+}
+
+// insert adds a message, or a reply to its thread,
+// unless it is there already. Replies whose parent is missing,
+// and replies also sent to the channel, are added as messages.
+func insert(d *structs.Data, msg slack.Message) {
+	isReply := msg.ThreadTimestamp != "" && msg.ThreadTimestamp != msg.Timestamp
+
+	if isReply {
+		for i := range d.Messages {
+			parent := &d.Messages[i]
+			if parent.Timestamp != msg.ThreadTimestamp {
+				continue
+			}
+
+			if !slices.ContainsFunc(parent.Replies, func(r slack.Message) bool { return r.Timestamp == msg.Timestamp }) {
+				// replies are oldest first, like conversations.replies
+				parent.Replies = append(parent.Replies, msg)
+				sort.SliceStable(parent.Replies, func(a, b int) bool {
+					return parent.Replies[a].Timestamp < parent.Replies[b].Timestamp
+				})
+				parent.ReplyCount = len(parent.Replies)
+				parent.LatestReply = parent.Replies[len(parent.Replies)-1].Timestamp
+			}
+
+			if msg.SubType != slack.MsgSubTypeThreadBroadcast {
+				return
+			}
+			break
+		}
+	}
+
+	for _, m := range d.Messages {
+		if m.Timestamp == msg.Timestamp {
+			return
+		}
+	}
+
+	// messages are newest first, like conversations.history
+	i := sort.Search(len(d.Messages), func(i int) bool {
+		return d.Messages[i].Timestamp < msg.Timestamp
+	})
+	d.Messages = slices.Insert(d.Messages, i, structs.Message{Message: msg})
+}
+
+func addReaction(msg *slack.Message, name, user string) {
+	for i := range msg.Reactions {
+		r := &msg.Reactions[i]
+		if r.Name != name {
+			continue
+		}
+
+		// users may be cut short on popular reactions, count doesn't
+		if !slices.Contains(r.Users, user) {
+			r.Users = append(r.Users, user)
+			r.Count++
+		}
+		return
+	}
+
+	msg.Reactions = append(msg.Reactions, slack.ItemReaction{Name: name, Count: 1, Users: []string{user}})
+}
+
+func removeReaction(msg *slack.Message, name, user string) {
+	for i := range msg.Reactions {
+		r := &msg.Reactions[i]
+		if r.Name != name {
+			continue
+		}
+
+		if !slices.Contains(r.Users, user) {
+			return
+		}
+
+		r.Users = slices.DeleteFunc(r.Users, func(u string) bool { return u == user })
+		r.Count--
+		if r.Count <= 0 {
+			msg.Reactions = slices.Delete(msg.Reactions, i, i+1)
+		}
+		return
+	}
+}
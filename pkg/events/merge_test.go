@@ -0,0 +1,92 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+func entry(t *testing.T, id, event string) Entry {
+	t.Helper()
+
+	if !json.Valid([]byte(event)) {
+		t.Fatalf("event %s is not valid JSON", id)
+	}
+
+	return Entry{
+		Received: time.Date(2023, 11, 14, 22, 30, 0, 0, time.UTC),
+		EventID:  id,
+		Event:    json.RawMessage(event),
+	}
+}
+
+// The log holds the original text, an unfurl and a reply count
+// update of a message the export already has in its edited version.
+func TestApplyTwiceKeepsNewerExport(t *testing.T) {
+	log := []Entry{
+		entry(t, "Ev1", `{"type": "message", "channel": "C1", "user": "U1", "text": "draft", "ts": "1700000100.000100"}`),
+		entry(t, "Ev2", `{"type": "message", "subtype": "message_changed", "channel": "C1",
+		  "message": {"type": "message", "user": "U1", "text": "draft", "ts": "1700000100.000100",
+		    "attachments": [{"title": "preview"}]}}`),
+		entry(t, "Ev3", `{"type": "message", "subtype": "message_replied", "channel": "C1",
+		  "message": {"type": "message", "user": "U1", "text": "draft", "ts": "1700000100.000100",
+		    "thread_ts": "1700000100.000100", "reply_count": 1}}`),
+	}
+
+	msg := slack.Message{}
+	msg.Type = "message"
+	msg.User = "U1"
+	msg.Text = "final"
+	msg.Timestamp = "1700000100.000100"
+	msg.Edited = &slack.Edited{User: "U1", Timestamp: "1700000200.000000"}
+
+	d := &structs.Data{Messages: []structs.Message{{Message: msg}}}
+
+	for i := range 2 {
+		if err := Apply(d, log); err != nil {
+			t.Fatalf("Apply %d: %v", i+1, err)
+		}
+
+		if len(d.Messages) != 1 {
+			t.Fatalf("after merge %d: %d messages, want 1", i+1, len(d.Messages))
+		}
+		if got := d.Messages[0].Text; got != "final" {
+			t.Errorf("after merge %d: text = %q, want the edited version", i+1, got)
+		}
+		if got := structs.EditedTimestamp(d.Messages[0].Msg); got != "1700000200.000000" {
+			t.Errorf("after merge %d: edited = %q", i+1, got)
+		}
+		if len(d.History) != 0 {
+			t.Errorf("after merge %d: history = %+v, want none", i+1, d.History)
+		}
+	}
+}
+
+func TestApplyEdit(t *testing.T) {
+	log := []Entry{
+		entry(t, "Ev1", `{"type": "message", "channel": "C1", "user": "U1", "text": "draft", "ts": "1700000100.000100"}`),
+		entry(t, "Ev2", `{"type": "message", "subtype": "message_changed", "channel": "C1",
+		  "message": {"type": "message", "user": "U1", "text": "final", "ts": "1700000100.000100",
+		    "edited": {"user": "U1", "ts": "1700000200.000000"}}}`),
+	}
+
+	d := &structs.Data{}
+	for i := range 2 {
+		if err := Apply(d, log); err != nil {
+			t.Fatalf("Apply %d: %v", i+1, err)
+		}
+	}
+
+	if len(d.Messages) != 1 || d.Messages[0].Text != "final" {
+		t.Fatalf("messages = %+v, want the edited message", d.Messages)
+	}
+
+	h := d.History["1700000100.000100"]
+	if h == nil || len(h.Versions) != 1 || h.Versions[0].Text != "draft" {
+		t.Errorf("history = %+v, want the draft as the earlier version", h)
+	}
+}
@@ -0,0 +1,540 @@
+// Package exporter exports Slack channels to files:
+// messages with their threads, the users who posted or were mentioned,
+// and optionally attachments and avatars.
+//
+// It talks to Slack through the API interface, which *slack.Client implements,
+// and reports progress through Options.OnProgress,
+// so it can be embedded in other programs:
+//
+//	e := exporter.New(slack.New(token), token, exporter.Options{
+//		Output:     "output",
+//		OnProgress: func(event exporter.Event) { ... },
+//	})
+//	if err := e.Export([]exporter.Target{{ID: "C0000000000"}}); err != nil {
+//		return err
+//	}
+//	return e.Finish()
+//
+// An Exporter is not safe for concurrent use.
+package exporter
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/slack-go/slack"
+	"golang.org/x/time/rate"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/redact"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// RequestsPerMinute is the rate of Slack API requests,
+// Tier 3 of the Slack rate limits.
+const RequestsPerMinute = 50
+
+// DefaultErrorReport is the name of the error report in the output directory.
+const DefaultErrorReport = "errors.json"
+
+// Options configure an Exporter.
+type Options struct {
+	// Output is the directory the files are written to.
+	Output string
+
+	// Format is FormatJSON (the default), FormatJSONL or FormatCSV.
+	Format string
+	// Location is the time zone of the times in CSV output, UTC if nil.
+	Location *time.Location
+	// Compress writes gzip-compressed .gz files.
+	Compress bool
+	// Bundle packs the output directory into a single archive next to it
+	// in Finish: structs.BundleZip or structs.BundleTarGz.
+	Bundle string
+	// Secret encrypts the written files, if set.
+	Secret *structs.Secret
+	// Redactor pseudonymizes users and masks personal data, if set.
+	// Files and avatars are never downloaded when redacting.
+	Redactor *redact.Redactor
+
+	DownloadFiles   bool
+	DownloadAvatars bool
+	IncludeArchived bool
+
+	// Incremental fetches only the messages since the newest one
+	// of the previous export of each channel, less Lookback,
+	// and keeps the older messages. Needs FormatJSON.
+	Incremental bool
+	// Lookback is how far before the newest exported message
+	// history is fetched again; DefaultLookback if zero.
+	Lookback time.Duration
+
+	// KeepGoing carries on when a channel, thread or file fails,
+	// and records the failures in the error report.
+	KeepGoing bool
+	// ErrorReport is the file name of the error report,
+	// relative to Output; DefaultErrorReport if empty.
+	ErrorReport string
+
+	// Profiles provides the profiles embedded in messages for users
+	// that users.info can't find, like people from other organizations
+	// in Slack Connect channels. The API client must send its requests
+	// through it. Such users are kept with their ID only if nil.
+	Profiles *ProfileCollector
+
+	// HTTPClient downloads files and avatars; http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// NoRateLimit sends requests as fast as the API answers,
+	// for replayed sessions that don't reach Slack.
+	NoRateLimit bool
+	// OnProgress is called with the progress of the export, if set.
+	// It is called from the goroutine running the export.
+	OnProgress func(Event)
+}
+
+// Target is a channel to export.
+// Info is only known for channels listed by type,
+// Name is only used to report progress.
+// Workspace is the ID of the Enterprise Grid workspace
+// whose subdirectory the channel is written to, if set.
+type Target struct {
+	ID        string
+	Name      string
+	Info      *slack.Channel
+	Workspace string
+}
+
+// Exporter exports channels through the Slack API.
+type Exporter struct {
+	api        API
+	token      string
+	opts       Options
+	limiter    *rate.Limiter
+	ctx        context.Context
+	httpClient *http.Client
+
+	seenUsers  map[string]interface{}
+	files      map[string]string // id -> url_private_download
+	usersCache map[string]*slack.User
+	report     *Report
+
+	lines        *lineIndex
+	workspaceURL string
+
+	// dir is the directory of the current channel:
+	// Output, or the subdirectory of the current workspace
+	dir               string
+	workspace         string
+	teams             map[string]slack.Team
+	workspaceChannels map[string]map[string]bool // team ID -> channel IDs
+	exported          map[string]bool
+
+	// auth is the workspace the token belongs to, see authTest
+	auth       *slack.AuthTestResponse
+	authErr    error
+	teamsCache map[string]*structs.Team
+}
+
+// New creates an Exporter. The token authorizes file downloads.
+func New(api API, token string, opts Options) *Exporter {
+	if opts.ErrorReport == "" {
+		opts.ErrorReport = DefaultErrorReport
+	}
+	if opts.Format == "" {
+		opts.Format = FormatJSON
+	}
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	if opts.Lookback == 0 {
+		opts.Lookback = DefaultLookback
+	}
+	if opts.Redactor != nil {
+		opts.DownloadFiles = false
+		opts.DownloadAvatars = false
+	}
+
+	e := &Exporter{
+		api:        api,
+		token:      token,
+		opts:       opts,
+		limiter:    rate.NewLimiter(rate.Every(time.Minute/RequestsPerMinute), 1),
+		ctx:        context.Background(),
+		httpClient: opts.HTTPClient,
+		seenUsers:  make(map[string]interface{}),
+		files:      make(map[string]string),
+		usersCache: make(map[string]*slack.User),
+		lines:      &lineIndex{users: map[string]*slack.User{}},
+		dir:        opts.Output,
+
+		teams:             make(map[string]slack.Team),
+		workspaceChannels: make(map[string]map[string]bool),
+		exported:          make(map[string]bool),
+		teamsCache:        make(map[string]*structs.Team),
+	}
+
+	if e.httpClient == nil {
+		e.httpClient = http.DefaultClient
+	}
+
+	if opts.NoRateLimit {
+		e.limiter = rate.NewLimiter(rate.Inf, 1)
+	}
+
+	if opts.KeepGoing {
+		e.report = &Report{}
+	}
+
+	return e
+}
+
+// Export exports the targets one by one, then downloads avatars
+// if requested. Unless KeepGoing is set, it stops at the first failure.
+// Channels that were already exported, like channels shared between
+// workspaces, are skipped. Call Finish when done.
+func (e *Exporter) Export(targets []Target) error {
+	if e.opts.Incremental && e.opts.Format != FormatJSON {
+		return errIncrementalNeedsJSON
+	}
+	if e.opts.Incremental && e.opts.Redactor != nil {
+		return errIncrementalRedacted
+	}
+
+	e.lookupWorkspaceURL()
+
+	for i, t := range targets {
+		if e.exported[t.ID] {
+			slog.Debug("Skipping channel that was already exported", logging.KeyChannel, t.ID, "workspace", t.Workspace)
+			continue
+		}
+
+		e.notify(ChannelStarted{Index: i + 1, Total: len(targets), ID: t.ID, Name: cmp.Or(t.Name, t.ID)})
+
+		err := e.setWorkspace(t.Workspace)
+		if err == nil {
+			err = e.ExportChannel(t.ID)
+		}
+		e.notify(ChannelDone{ID: t.ID, Err: err})
+
+		if err != nil {
+			if !e.opts.KeepGoing {
+				return fmt.Errorf("could not export channel %q: %w", cmp.Or(t.Name, t.ID), err)
+			}
+			slog.Warn("Could not export channel", logging.KeyChannel, t.ID, "name", t.Name, logging.Err(err))
+			e.report.add(channelFailure(t.Workspace, t.ID, err))
+		}
+	}
+
+	return e.finishChannels()
+}
+
+// lookupWorkspaceURL gets the workspace URL for the permalinks in CSV output.
+func (e *Exporter) lookupWorkspaceURL() {
+	if e.opts.Format != FormatCSV || e.workspaceURL != "" {
+		return
+	}
+
+	var err error
+	e.workspaceURL, err = e.WorkspaceURL()
+	if err != nil {
+		// permalinks are nice to have, the export itself still works
+		slog.Warn("Could not get workspace URL, permalinks are left empty", logging.Err(err))
+	}
+}
+
+// finishChannels downloads the avatars of all exported users, if requested.
+func (e *Exporter) finishChannels() error {
+	if e.opts.DownloadAvatars {
+		slog.Info("Downloading avatars", "users", len(e.usersCache))
+		if err := e.downloadAvatars(); err != nil {
+			return fmt.Errorf("could not download avatars: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ExportChannel exports a single channel into the directory
+// of the workspace of the last exported target.
+// Archived channels are skipped unless IncludeArchived is set.
+func (e *Exporter) ExportChannel(channelID string) error {
+	channelInfo, err := e.ChannelInfo(channelID)
+	if err != nil {
+		return fmt.Errorf("could not get channel %q info: %w", channelID, err)
+	}
+
+	if channelInfo.IsArchived && !e.opts.IncludeArchived {
+		return nil
+	}
+
+	e.exported[channelID] = true
+	e.addToWorkspace(channelID)
+
+	if err := e.loadExistingUsers(channelID); err != nil {
+		return err
+	}
+
+	previous, oldest, err := e.previousExport(channelID)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := e.messages(channelID, oldest)
+	if err != nil {
+		return fmt.Errorf("could not get messages: %w", err)
+	}
+
+	if previous != nil {
+		msgs = trackChanges(previous, msgs, oldest, time.Now())
+		msgs = mergeMessages(previous.Messages, msgs, oldest)
+		e.keepPrevious(previous)
+	}
+
+	return e.writeChannel(channelInfo, msgs, previous)
+}
+
+// loadExistingUsers reads the users of a previous export of the channel,
+// so that they don't have to be looked up again.
+func (e *Exporter) loadExistingUsers(channelID string) error {
+	// users in redacted files are pseudonymized and can't be reused
+	existing := e.existingChannelFilename(channelID)
+	if existing == "" || e.opts.Redactor != nil {
+		return nil
+	}
+
+	d, err := structs.ReadFile(existing, e.opts.Secret)
+	if err != nil {
+		return fmt.Errorf("could not read file: %w", err)
+	}
+
+	for id, user := range d.Users {
+		e.usersCache[id] = user
+	}
+
+	return nil
+}
+
+// writeChannel downloads the files and looks up the users of the messages,
+// and writes the channel in the output format. The files and message
+// history of the previous export, if any, are kept.
+func (e *Exporter) writeChannel(channelInfo *slack.Channel, msgs []structs.Message, previous *structs.Data) error {
+	channelID := channelInfo.ID
+	outputFilename := e.channelFilename(channelID)
+	existing := e.existingChannelFilename(channelID)
+
+	var (
+		files map[string]string
+		err   error
+	)
+	if e.opts.DownloadFiles {
+		files, err = e.downloadFiles(channelID)
+		if err != nil {
+			return fmt.Errorf("could not download files: %w", err)
+		}
+
+		if previous != nil {
+			for id, filename := range previous.Files {
+				if _, ok := files[id]; !ok {
+					files[id] = filename
+				}
+			}
+		}
+	}
+
+	users, err := e.users()
+	if err != nil {
+		return fmt.Errorf("could not get users: %w", err)
+	}
+
+	data := structs.Data{
+		SchemaVersion: structs.SchemaVersion,
+		Channel:       *channelInfo,
+		Messages:      msgs,
+		Users:         users,
+		Files:         files,
+		Teams:         e.userTeams(users),
+	}
+
+	if previous != nil {
+		data.History = previous.History
+	}
+
+	if e.opts.Redactor != nil {
+		e.opts.Redactor.Data(&data)
+	}
+
+	switch e.opts.Format {
+	case FormatJSONL:
+		return e.writeChannelLines(&data)
+	case FormatCSV:
+		return e.writeChannelCSV(&data)
+	}
+
+	// Save to a file
+	content, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("could not marshal messages: %w", err)
+	}
+
+	if err = structs.WriteData(outputFilename, content, e.opts.Secret); err != nil {
+		return fmt.Errorf("could not write messages to file: %w", err)
+	}
+
+	// don't leave the channel twice, compressed and not
+	if existing != "" && existing != outputFilename {
+		if err := os.Remove(existing); err != nil {
+			return fmt.Errorf("could not remove previous export: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Reset forgets the channels exported and the failures recorded so far,
+// so that the Exporter can export the same channels again. The users,
+// teams and workspaces it has looked up are kept.
+func (e *Exporter) Reset() {
+	e.exported = make(map[string]bool)
+	e.files = make(map[string]string)
+	e.lines = &lineIndex{users: map[string]*slack.User{}}
+	e.resetSeenUsers()
+
+	if e.report != nil {
+		e.report = &Report{}
+	}
+}
+
+// Report returns the failures recorded so far,
+// or nil if neither KeepGoing nor Retry is used.
+func (e *Exporter) Report() *Report {
+	return e.report
+}
+
+// Finish writes the JSON Lines index, the workspace index, the error report
+// and the bundle, if they apply. It fails with ErrIncompleteExport
+// if anything could not be exported.
+func (e *Exporter) Finish() error {
+	if len(e.workspaceChannels) > 0 {
+		if err := e.writeWorkspaces(); err != nil {
+			return err
+		}
+	}
+
+	if e.opts.Format == FormatJSONL {
+		if err := e.writeIndex(); err != nil {
+			return err
+		}
+	}
+
+	var reportErr error
+	if e.report != nil {
+		reportErr = e.writeReport()
+	}
+
+	if e.opts.Bundle != "" {
+		if err := e.writeBundle(); err != nil {
+			return fmt.Errorf("could not write bundle: %w", err)
+		}
+	}
+
+	return reportErr
+}
+
+// writeBundle writes the manifest and packs the whole output directory
+// into a single archive next to it.
+func (e *Exporter) writeBundle() error {
+	entries, err := os.ReadDir(e.opts.Output)
+	if err != nil {
+		return fmt.Errorf("could not read output directory: %w", err)
+	}
+
+	var channels []string
+	for _, entry := range entries {
+		if channelID, ok := e.isChannelFile(entry.Name()); ok && !entry.IsDir() {
+			channels = append(channels, channelID)
+		}
+	}
+
+	// channels of Enterprise Grid workspaces are in subdirectories
+	index, err := structs.ReadWorkspaces(os.DirFS(e.opts.Output))
+	if err != nil {
+		return err
+	}
+	if index != nil {
+		for _, w := range index.Workspaces {
+			channels = append(channels, w.Channels...)
+		}
+	}
+
+	manifest, err := structs.NewManifest(e.opts.Output, channels)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal manifest: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(e.opts.Output, structs.ManifestName), content, 0o600); err != nil {
+		return fmt.Errorf("could not write manifest: %w", err)
+	}
+
+	bundle := filepath.Clean(e.opts.Output) + "." + e.opts.Bundle
+	slog.Info("Writing bundle", logging.KeyPath, bundle, "files", len(manifest.Files))
+
+	return structs.WriteBundle(e.opts.Output, bundle)
+}
+
+// channelFilename returns the path the channel is written to.
+func (e *Exporter) channelFilename(channelID string) string {
+	name := channelID + ".json"
+	if e.opts.Compress {
+		name += ".gz"
+	}
+
+	return filepath.Join(e.dir, name)
+}
+
+// checkNotExported returns errAlreadyExported if any of the channels
+// is in the output directory already, in any of the output formats.
+func (e *Exporter) checkNotExported(channelIDs []string) error {
+	for _, channelID := range channelIDs {
+		names := []string{channelID + ".json", channelID + ".jsonl", channelID + ".csv"}
+		for _, name := range names {
+			for _, p := range []string{filepath.Join(e.dir, name), filepath.Join(e.dir, name+".gz")} {
+				if _, err := os.Stat(p); err == nil {
+					return fmt.Errorf("%w: %s", errAlreadyExported, p)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// existingChannelFilename returns the path of a previous export of the channel,
+// compressed or not, or an empty string if there is none.
+func (e *Exporter) existingChannelFilename(channelID string) string {
+	// prefer the variant the next export writes
+	names := []string{channelID + ".json", channelID + ".json.gz"}
+	if e.opts.Compress {
+		names[0], names[1] = names[1], names[0]
+	}
+
+	for _, name := range names {
+		p := filepath.Join(e.dir, name)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+
+	return ""
+}
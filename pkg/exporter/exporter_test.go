@@ -0,0 +1,430 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+var errFake = errors.New("fake failure")
+
+// fakeAPI serves channels from memory. History and replies are served
+// in pages, the cursor of each page being its index.
+type fakeAPI struct {
+	channels map[string]*slack.Channel
+	history  map[string][][]slack.Message // channel -> pages, newest first
+	replies  map[string][][]slack.Message // thread ts -> pages, parent first
+	users    map[string]*slack.User
+
+	// matches are returned by search.messages on a single page
+	matches []slack.SearchMessage
+
+	// failing channels and threads return errFake
+	failing map[string]bool
+
+	cursors []string // cursors requested, as method:cursor
+}
+
+func (f *fakeAPI) AuthTest() (*slack.AuthTestResponse, error) {
+	return &slack.AuthTestResponse{URL: "https://example.slack.com/", TeamID: "T1"}, nil
+}
+
+func (f *fakeAPI) GetConversations(*slack.GetConversationsParameters) ([]slack.Channel, string, error) {
+	return nil, "", nil
+}
+
+func (f *fakeAPI) GetConversationInfo(input *slack.GetConversationInfoInput) (*slack.Channel, error) {
+	c, ok := f.channels[input.ChannelID]
+	if !ok || f.failing[input.ChannelID] {
+		return nil, fmt.Errorf("%w: channel %s", errFake, input.ChannelID)
+	}
+
+	return c, nil
+}
+
+func (f *fakeAPI) GetConversationHistory(params *slack.GetConversationHistoryParameters) (*slack.GetConversationHistoryResponse, error) {
+	f.cursors = append(f.cursors, "conversations.history:"+params.Cursor)
+
+	msgs, next := page(f.history[params.ChannelID], params.Cursor)
+	resp := &slack.GetConversationHistoryResponse{Messages: msgs}
+	resp.ResponseMetaData.NextCursor = next
+	return resp, nil
+}
+
+func (f *fakeAPI) GetConversationReplies(params *slack.GetConversationRepliesParameters) ([]slack.Message, bool, string, error) {
+	if f.failing[params.Timestamp] {
+		return nil, false, "", fmt.Errorf("%w: thread %s", errFake, params.Timestamp)
+	}
+
+	f.cursors = append(f.cursors, "conversations.replies:"+params.Cursor)
+
+	msgs, next := page(f.replies[params.Timestamp], params.Cursor)
+	return msgs, next != "", next, nil
+}
+
+func (f *fakeAPI) GetUserInfo(user string) (*slack.User, error) {
+	u, ok := f.users[user]
+	if !ok {
+		return nil, errors.New("user_not_found")
+	}
+
+	return u, nil
+}
+
+func (f *fakeAPI) ListTeams(slack.ListTeamsParameters) ([]slack.Team, string, error) {
+	return nil, "", nil
+}
+
+func (f *fakeAPI) GetOtherTeamInfo(string) (*slack.TeamInfo, error) {
+	return nil, errFake
+}
+
+func (f *fakeAPI) SearchMessages(string, slack.SearchParameters) (*slack.SearchMessages, error) {
+	return &slack.SearchMessages{
+		Matches: f.matches,
+		Paging:  slack.Paging{Page: 1, Pages: 1},
+	}, nil
+}
+
+// page returns the page at the cursor and the cursor of the next one.
+func page(pages [][]slack.Message, cursor string) ([]slack.Message, string) {
+	i := 0
+	if cursor != "" {
+		fmt.Sscan(cursor, &i)
+	}
+	if i >= len(pages) {
+		return nil, ""
+	}
+
+	next := ""
+	if i+1 < len(pages) {
+		next = fmt.Sprint(i + 1)
+	}
+
+	return pages[i], next
+}
+
+func msg(ts, user, text string) slack.Message {
+	m := slack.Message{}
+	m.Type = "message"
+	m.Timestamp = ts
+	m.User = user
+	m.Text = text
+	return m
+}
+
+func reply(ts, thread, user, text string) slack.Message {
+	m := msg(ts, user, text)
+	m.ThreadTimestamp = thread
+	return m
+}
+
+func parent(ts, user, text string, replies int) slack.Message {
+	m := reply(ts, ts, user, text)
+	m.ReplyCount = replies
+	return m
+}
+
+// newFakeAPI returns a workspace with two channels:
+// C1 with two pages of history and a thread with two pages of replies,
+// C2 with a single thread.
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{
+		channels: map[string]*slack.Channel{
+			"C1": {GroupConversation: slack.GroupConversation{Name: "general", Conversation: slack.Conversation{ID: "C1"}}},
+			"C2": {GroupConversation: slack.GroupConversation{Name: "random", Conversation: slack.Conversation{ID: "C2"}}},
+		},
+		history: map[string][][]slack.Message{
+			"C1": {
+				{msg("1700000300.000100", "U1", "third"), parent("1700000200.000100", "U2", "second", 2)},
+				{msg("1700000100.000100", "U1", "first")},
+			},
+			"C2": {
+				{parent("1700000500.000100", "U1", "question", 1)},
+			},
+		},
+		replies: map[string][][]slack.Message{
+			"1700000200.000100": {
+				{parent("1700000200.000100", "U2", "second", 2), reply("1700000210.000100", "1700000200.000100", "U1", "reply one")},
+				{reply("1700000220.000100", "1700000200.000100", "U2", "reply two")},
+			},
+			"1700000500.000100": {
+				{parent("1700000500.000100", "U1", "question", 1), reply("1700000510.000100", "1700000500.000100", "U2", "answer")},
+			},
+		},
+		users: map[string]*slack.User{
+			"U1": {ID: "U1", Name: "ann", TeamID: "T1"},
+			"U2": {ID: "U2", Name: "bob", TeamID: "T1"},
+		},
+		failing: map[string]bool{},
+	}
+}
+
+func readChannel(t *testing.T, output, channelID string) *structs.Data {
+	t.Helper()
+
+	data, err := structs.ReadFile(filepath.Join(output, channelID+".json"), nil)
+	if err != nil {
+		t.Fatalf("could not read %s: %v", channelID, err)
+	}
+
+	return data
+}
+
+func TestExport(t *testing.T) {
+	api := newFakeAPI()
+	output := t.TempDir()
+	e := New(api, "xoxp-test", Options{Output: output, NoRateLimit: true})
+
+	if err := e.Export([]Target{{ID: "C1"}}); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if err := e.Finish(); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+
+	wantCursors := []string{
+		"conversations.history:", "conversations.history:1",
+		"conversations.replies:", "conversations.replies:1",
+	}
+	if !slices.Equal(api.cursors, wantCursors) {
+		t.Errorf("requested %q, want %q", api.cursors, wantCursors)
+	}
+
+	data := readChannel(t, output, "C1")
+	if data.Channel.Name != "general" || data.SchemaVersion != structs.SchemaVersion {
+		t.Errorf("channel = %q, schema %d", data.Channel.Name, data.SchemaVersion)
+	}
+
+	var got []string
+	for _, m := range data.Messages {
+		got = append(got, m.Text)
+		for _, r := range m.Replies {
+			got = append(got, "  "+r.Text)
+		}
+	}
+
+	// newest first, replies oldest first without the parent
+	want := []string{"third", "second", "  reply one", "  reply two", "first"}
+	if !slices.Equal(got, want) {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+
+	if len(data.Users) != 2 || data.Users["U1"] == nil || data.Users["U2"] == nil {
+		t.Errorf("users = %v, want U1 and U2", data.Users)
+	}
+
+	if _, err := os.Stat(filepath.Join(output, DefaultErrorReport)); !os.IsNotExist(err) {
+		t.Errorf("error report was written without KeepGoing: %v", err)
+	}
+}
+
+func TestExportKeepGoing(t *testing.T) {
+	api := newFakeAPI()
+	api.failing["CBAD"] = true
+	api.failing["1700000500.000100"] = true
+
+	output := t.TempDir()
+	e := New(api, "xoxp-test", Options{Output: output, NoRateLimit: true, KeepGoing: true})
+
+	if err := e.Export([]Target{{ID: "C1"}, {ID: "CBAD"}, {ID: "C2"}}); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if err := e.Finish(); !errors.Is(err, ErrIncompleteExport) {
+		t.Fatalf("Finish = %v, want %v", err, ErrIncompleteExport)
+	}
+
+	// the channels around the failure are exported,
+	// the thread that failed is kept without replies
+	readChannel(t, output, "C1")
+	if c2 := readChannel(t, output, "C2"); len(c2.Messages) != 1 || len(c2.Messages[0].Replies) != 0 {
+		t.Errorf("C2 messages = %+v, want the thread parent only", c2.Messages)
+	}
+
+	report, err := ReadReport(filepath.Join(output, DefaultErrorReport))
+	if err != nil {
+		t.Fatalf("could not read report: %v", err)
+	}
+
+	want := []Failure{
+		{Kind: FailureChannel, Channel: "CBAD"},
+		{Kind: FailureThread, Channel: "C2", Thread: "1700000500.000100"},
+	}
+	if len(report.Failures) != len(want) {
+		t.Fatalf("failures = %+v, want %+v", report.Failures, want)
+	}
+	for i, f := range report.Failures {
+		if f.Kind != want[i].Kind || f.Channel != want[i].Channel || f.Thread != want[i].Thread || f.Error == "" {
+			t.Errorf("failure %d = %+v, want %+v", i, f, want[i])
+		}
+	}
+}
+
+func TestExportStopsOnFailure(t *testing.T) {
+	api := newFakeAPI()
+	api.failing["CBAD"] = true
+
+	output := t.TempDir()
+	e := New(api, "xoxp-test", Options{Output: output, NoRateLimit: true})
+
+	if err := e.Export([]Target{{ID: "C1"}, {ID: "CBAD"}, {ID: "C2"}}); !errors.Is(err, errFake) {
+		t.Fatalf("Export = %v, want %v", err, errFake)
+	}
+
+	if _, err := os.Stat(filepath.Join(output, "C2.json")); !os.IsNotExist(err) {
+		t.Errorf("C2 was exported after the failure: %v", err)
+	}
+}
+
+func TestExportSearchKeepsFullExport(t *testing.T) {
+	api := newFakeAPI()
+	api.matches = []slack.SearchMessage{
+		{Type: "message", Channel: slack.CtxChannel{ID: "C1"}, User: "U1", Timestamp: "1700000300.000100", Text: "third"},
+	}
+
+	output := t.TempDir()
+	full := filepath.Join(output, "C1.json.gz")
+	if err := os.WriteFile(full, []byte("full export"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(api, "xoxp-test", Options{Output: output, NoRateLimit: true})
+	if err := e.ExportSearch("third", false); !errors.Is(err, errAlreadyExported) {
+		t.Fatalf("ExportSearch = %v, want %v", err, errAlreadyExported)
+	}
+
+	if content, err := os.ReadFile(full); err != nil || string(content) != "full export" {
+		t.Errorf("full export was changed: %q, %v", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(output, "C1.json")); !os.IsNotExist(err) {
+		t.Errorf("matches were written next to the full export: %v", err)
+	}
+}
+
+func TestExportThreadsKeepsFullExport(t *testing.T) {
+	api := newFakeAPI()
+
+	output := t.TempDir()
+	full := filepath.Join(output, "C1.json")
+	if err := os.WriteFile(full, []byte("full export"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(api, "xoxp-test", Options{Output: output, NoRateLimit: true})
+	err := e.ExportThreads([]ThreadRef{{Channel: "C2", Timestamp: "1700000500.000100"}, {Channel: "C1", Timestamp: "1700000200.000100"}})
+	if !errors.Is(err, errAlreadyExported) {
+		t.Fatalf("ExportThreads = %v, want %v", err, errAlreadyExported)
+	}
+
+	if content, err := os.ReadFile(full); err != nil || string(content) != "full export" {
+		t.Errorf("full export was changed: %q, %v", content, err)
+	}
+
+	// nothing is written when any of the channels is exported already
+	if _, err := os.Stat(filepath.Join(output, "C2.json")); !os.IsNotExist(err) {
+		t.Errorf("C2 was written: %v", err)
+	}
+}
+
+func TestRetrySearchThread(t *testing.T) {
+	api := newFakeAPI()
+	api.matches = []slack.SearchMessage{{
+		Type:      "message",
+		Channel:   slack.CtxChannel{ID: "C1"},
+		User:      "U1",
+		Timestamp: "1700000210.000100",
+		Text:      "reply one",
+		Permalink: "https://example.slack.com/archives/C1/p1700000210000100?thread_ts=1700000200.000100",
+	}}
+	api.failing["1700000200.000100"] = true
+
+	output := t.TempDir()
+	e := New(api, "xoxp-test", Options{Output: output, NoRateLimit: true, KeepGoing: true})
+	if err := e.ExportSearch("reply", true); err != nil {
+		t.Fatalf("ExportSearch: %v", err)
+	}
+	if err := e.Finish(); !errors.Is(err, ErrIncompleteExport) {
+		t.Fatalf("Finish = %v, want %v", err, ErrIncompleteExport)
+	}
+
+	report, err := ReadReport(filepath.Join(output, DefaultErrorReport))
+	if err != nil {
+		t.Fatalf("could not read report: %v", err)
+	}
+
+	// the match is kept without its parent until the retry
+	delete(api.failing, "1700000200.000100")
+	e = New(api, "xoxp-test", Options{Output: output, NoRateLimit: true, KeepGoing: true})
+	if err := e.Retry(report); err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	if err := e.Finish(); err != nil {
+		t.Fatalf("Finish after retry: %v", err)
+	}
+
+	data := readChannel(t, output, "C1")
+
+	var got []string
+	for _, m := range data.Messages {
+		got = append(got, m.Text)
+		for _, r := range m.Replies {
+			got = append(got, "  "+r.Text)
+		}
+	}
+
+	want := []string{"second", "  reply one", "  reply two"}
+	if !slices.Equal(got, want) {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+}
+
+func TestParseThreadRef(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ThreadRef
+	}{
+		{"C1:1700000000.000100", ThreadRef{"C1", "1700000000.000100"}},
+		{"https://example.slack.com/archives/C1/p1700000000000100", ThreadRef{"C1", "1700000000.000100"}},
+		// replies refer to their thread
+		{"https://example.slack.com/archives/C1/p1700000000000200?thread_ts=1700000000.000100&cid=C1", ThreadRef{"C1", "1700000000.000100"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseThreadRef(tt.in)
+		if err != nil {
+			t.Errorf("ParseThreadRef(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseThreadRef(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"C1",
+		":1700000000.000100",
+		"C1:1700000000",
+		"C1:.000100",
+		"C1:1700000000.",
+		"C1:abc.def",
+		"C1:1700000000.000100x",
+		"https://example.slack.com/archives/C1",
+		"https://example.slack.com/archives//p1700000000000100",
+		"https://example.slack.com/archives/C1/1700000000000100",
+		"https://example.slack.com/archives/C1/p123456",
+		"https://example.slack.com/archives/C1/p17000000000001x0",
+		"https://example.slack.com/archives/C1/p1700000000000200?thread_ts=abc",
+		"https://example.slack.com/messages/C1/p1700000000000100",
+	} {
+		if got, err := ParseThreadRef(in); !errors.Is(err, errBadThreadRef) {
+			t.Errorf("ParseThreadRef(%q) = %+v, %v, want %v", in, got, err, errBadThreadRef)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// Output formats.
+const (
+	FormatJSON  = "json"
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// JSON Lines files with the channels and users of all exported channels.
+const (
+	channelsLinesName = "channels.jsonl"
+	usersLinesName    = "users.jsonl"
+)
+
+var errRetryNeedsJSON = fmt.Errorf("retrying threads and files needs the JSON output format")
+
+// lineIndex collects the channels and users of a JSON Lines export,
+// written to channels.jsonl and users.jsonl once all channels are done.
+type lineIndex struct {
+	channels []structs.LineChannel
+	users    map[string]*slack.User
+}
+
+// linesFilename returns the path of a JSON Lines or CSV file in the directory.
+func (e *Exporter) linesFilename(dir, name string) string {
+	if e.opts.Compress {
+		name += ".gz"
+	}
+
+	return filepath.Join(dir, name)
+}
+
+// isChannelFile reports whether the file in the output directory
+// is an exported channel, in any of the output formats.
+func (e *Exporter) isChannelFile(name string) (channelID string, ok bool) {
+	switch strings.TrimSuffix(name, ".gz") {
+	case structs.ManifestName, structs.WorkspacesName, e.opts.ErrorReport, channelsLinesName, usersLinesName:
+		return "", false
+	}
+
+	if structs.IsDataFile(name) {
+		return structs.TrimDataExt(name), true
+	}
+
+	base := strings.TrimSuffix(name, ".gz")
+	for _, ext := range []string{".jsonl", ".csv"} {
+		if strings.HasSuffix(base, ext) {
+			return strings.TrimSuffix(base, ext), true
+		}
+	}
+
+	return "", false
+}
+
+// writeChannelLines writes the messages of the channel as JSON Lines,
+// and remembers the channel and its users for the index files.
+func (e *Exporter) writeChannelLines(data *structs.Data) error {
+	err := e.writeLines(e.linesFilename(e.dir, data.Channel.ID+".jsonl"), func(enc *json.Encoder) error {
+		for _, line := range structs.Lines(data, e.workspace) {
+			if err := enc.Encode(line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not write messages to file: %w", err)
+	}
+
+	e.lines.channels = append(e.lines.channels, structs.LineChannelOf(data))
+	for id, user := range data.Users {
+		e.lines.users[id] = user
+	}
+
+	return nil
+}
+
+// writeChannelCSV writes the messages of the channel as CSV.
+func (e *Exporter) writeChannelCSV(data *structs.Data) error {
+	w, err := structs.CreateData(e.linesFilename(e.dir, data.Channel.ID+".csv"), e.opts.Secret)
+	if err != nil {
+		return fmt.Errorf("could not create file: %w", err)
+	}
+
+	cw := structs.NewCSVWriter(w, e.opts.Location, e.workspaceURL)
+	if err := cw.Write(data); err != nil {
+		w.Close()
+		return fmt.Errorf("could not write messages to file: %w", err)
+	}
+
+	if err := cw.Flush(); err != nil {
+		w.Close()
+		return fmt.Errorf("could not write messages to file: %w", err)
+	}
+
+	return w.Close()
+}
+
+// writeIndex writes channels.jsonl and users.jsonl.
+func (e *Exporter) writeIndex() error {
+	err := e.writeLines(e.linesFilename(e.opts.Output, channelsLinesName), func(enc *json.Encoder) error {
+		for _, channel := range e.lines.channels {
+			if err := enc.Encode(channel); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not write channels: %w", err)
+	}
+
+	err = e.writeLines(e.linesFilename(e.opts.Output, usersLinesName), func(enc *json.Encoder) error {
+		for _, user := range structs.LineUsers(e.lines.users) {
+			if err := enc.Encode(user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not write users: %w", err)
+	}
+
+	return nil
+}
+
+func (e *Exporter) writeLines(path string, encode func(enc *json.Encoder) error) error {
+	w, err := structs.CreateData(path, e.opts.Secret)
+	if err != nil {
+		return err
+	}
+
+	if err := encode(json.NewEncoder(w)); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
@@ -0,0 +1,160 @@
+package exporter
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// DefaultLookback is how far before the newest exported message
+// incremental exports fetch again, to pick up edits and late replies.
+const DefaultLookback = 24 * time.Hour
+
+var (
+	errIncrementalNeedsJSON = fmt.Errorf("incremental exports need the JSON output format")
+	errIncrementalRedacted  = fmt.Errorf("incremental exports can't be redacted")
+)
+
+// previousExport reads the previous export of the channel for an
+// incremental export, and returns the timestamp to fetch history from.
+// It returns nil if the channel has to be fetched in full.
+func (e *Exporter) previousExport(channelID string) (*structs.Data, string, error) {
+	existing := e.existingChannelFilename(channelID)
+	if !e.opts.Incremental || existing == "" {
+		return nil, "", nil
+	}
+
+	d, err := structs.ReadFile(existing, e.opts.Secret)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read previous export: %w", err)
+	}
+
+	if len(d.Messages) == 0 {
+		return nil, "", nil
+	}
+
+	// messages are newest first
+	newest, err := structs.ParseTimestamp(d.Messages[0].Timestamp)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not parse newest message time: %w", err)
+	}
+
+	oldest := newest.Add(-e.opts.Lookback)
+	ts := fmt.Sprintf("%d.%06d", oldest.Unix(), oldest.Nanosecond()/1000)
+
+	slog.Debug("Fetching new messages", logging.KeyChannel, channelID, "oldest", ts)
+
+	return d, ts, nil
+}
+
+// trackChanges compares the fetched messages with the messages of the
+// previous export in the fetched window. Earlier versions of edited
+// messages and replies are kept in the history of the previous export,
+// and messages and replies that are gone are kept and marked deleted,
+// with the time they were noticed. It returns the fetched messages
+// with the deleted ones, newest first.
+func trackChanges(previous *structs.Data, fetched []structs.Message, oldest string, now time.Time) []structs.Message {
+	byTimestamp := make(map[string]*structs.Message, len(fetched))
+	for i := range fetched {
+		byTimestamp[fetched[i].Timestamp] = &fetched[i]
+	}
+
+	var deleted []structs.Message
+	for _, old := range previous.Messages {
+		if old.Timestamp < oldest {
+			continue
+		}
+
+		msg, ok := byTimestamp[old.Timestamp]
+		if !ok {
+			previous.MarkDeleted(old.Timestamp, now)
+			deleted = append(deleted, old)
+			continue
+		}
+
+		if msg.SubType == "tombstone" {
+			// a deleted message with replies; keep what it said
+			previous.MarkDeleted(old.Timestamp, now)
+			msg.Msg = old.Msg
+		} else if structs.IsEdit(old.Msg, msg.Msg) {
+			previous.AddVersion(old.Msg, now)
+		}
+
+		msg.Replies = trackReplies(previous, old, *msg, now)
+	}
+
+	fetched = append(fetched, deleted...)
+	sort.SliceStable(fetched, func(i, j int) bool {
+		return fetched[i].Timestamp > fetched[j].Timestamp
+	})
+
+	return fetched
+}
+
+// trackReplies compares the fetched replies of a thread with the previous
+// ones, like trackChanges, and returns them oldest first.
+func trackReplies(previous *structs.Data, old, msg structs.Message, now time.Time) []slack.Message {
+	if msg.ReplyCount > 0 && len(msg.Replies) == 0 {
+		// the replies could not be fetched, nothing is known about them
+		return old.Replies
+	}
+
+	replies := msg.Replies
+	for _, reply := range old.Replies {
+		i := slices.IndexFunc(replies, func(r slack.Message) bool {
+			return r.Timestamp == reply.Timestamp
+		})
+		if i < 0 {
+			previous.MarkDeleted(reply.Timestamp, now)
+			replies = append(replies, reply)
+			continue
+		}
+
+		if structs.IsEdit(reply.Msg, replies[i].Msg) {
+			previous.AddVersion(reply.Msg, now)
+		}
+	}
+
+	sort.SliceStable(replies, func(i, j int) bool {
+		return replies[i].Timestamp < replies[j].Timestamp
+	})
+
+	return replies
+}
+
+// mergeMessages returns the fetched messages followed by the previous
+// messages older than the fetched window, newest first.
+func mergeMessages(previous, fetched []structs.Message, oldest string) []structs.Message {
+	merged := make([]structs.Message, 0, len(previous)+len(fetched))
+	merged = append(merged, fetched...)
+
+	for _, msg := range previous {
+		if msg.Timestamp < oldest {
+			merged = append(merged, msg)
+		}
+	}
+
+	return merged
+}
+
+// keepPrevious adds the users of the previous export to the channel,
+// as they may have posted the kept messages, and skips downloading
+// the files that were downloaded before.
+func (e *Exporter) keepPrevious(previous *structs.Data) {
+	for id := range previous.Users {
+		e.seenUsers[id] = nil
+	}
+
+	for id, filename := range previous.Files {
+		if filename != "" {
+			delete(e.files, id)
+		}
+	}
+}
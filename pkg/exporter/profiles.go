@@ -0,0 +1,136 @@
+package exporter
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/slack-go/slack"
+)
+
+// ProfileCollector is an http.RoundTripper that picks the author profiles
+// out of conversations.history and conversations.replies responses.
+// Slack embeds them in messages of Slack Connect channels, and they are
+// the only information about people from other organizations,
+// whom users.info doesn't find. slack-go doesn't decode them.
+type ProfileCollector struct {
+	next http.RoundTripper
+
+	mu    sync.Mutex
+	users map[string]*slack.User
+}
+
+// messageAuthor is the author information embedded in a message.
+type messageAuthor struct {
+	User        string `json:"user"`
+	UserTeam    string `json:"user_team"`
+	Team        string `json:"team"`
+	UserProfile *struct {
+		Name              string `json:"name"`
+		RealName          string `json:"real_name"`
+		DisplayName       string `json:"display_name"`
+		FirstName         string `json:"first_name"`
+		AvatarHash        string `json:"avatar_hash"`
+		Image72           string `json:"image_72"`
+		Team              string `json:"team"`
+		IsRestricted      bool   `json:"is_restricted"`
+		IsUltraRestricted bool   `json:"is_ultra_restricted"`
+	} `json:"user_profile"`
+}
+
+// NewProfileCollector creates a ProfileCollector sending requests
+// through next, or http.DefaultTransport if nil.
+func NewProfileCollector(next http.RoundTripper) *ProfileCollector {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &ProfileCollector{next: next, users: map[string]*slack.User{}}
+}
+
+// RoundTrip sends the request and collects the profiles from the response.
+func (c *ProfileCollector) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := c.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	if !strings.HasSuffix(req.URL.Path, "/conversations.history") &&
+		!strings.HasSuffix(req.URL.Path, "/conversations.replies") {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read response: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var page struct {
+		Messages []messageAuthor `json:"messages"`
+	}
+	// errors are left to the API client, which decodes the same body
+	if json.Unmarshal(body, &page) == nil {
+		c.collect(page.Messages)
+	}
+
+	return resp, nil
+}
+
+// collect remembers the authors of the messages,
+// preferring the ones with a profile.
+func (c *ProfileCollector) collect(messages []messageAuthor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, m := range messages {
+		if m.User == "" {
+			continue
+		}
+
+		p := m.UserProfile
+		if p == nil {
+			if _, ok := c.users[m.User]; !ok && cmp.Or(m.UserTeam, m.Team) != "" {
+				c.users[m.User] = &slack.User{ID: m.User, TeamID: cmp.Or(m.UserTeam, m.Team)}
+			}
+			continue
+		}
+
+		c.users[m.User] = &slack.User{
+			ID:                m.User,
+			TeamID:            cmp.Or(p.Team, m.UserTeam, m.Team),
+			Name:              p.Name,
+			RealName:          p.RealName,
+			IsRestricted:      p.IsRestricted,
+			IsUltraRestricted: p.IsUltraRestricted,
+			Profile: slack.UserProfile{
+				RealName:    p.RealName,
+				DisplayName: p.DisplayName,
+				FirstName:   p.FirstName,
+				AvatarHash:  p.AvatarHash,
+				Image72:     p.Image72,
+				Team:        cmp.Or(p.Team, m.UserTeam, m.Team),
+			},
+		}
+	}
+}
+
+// User returns the user as seen in messages, if any message was collected.
+func (c *ProfileCollector) User(id string) (*slack.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	u, ok := c.users[id]
+	if !ok {
+		return nil, false
+	}
+
+	copied := *u
+	return &copied, true
+}
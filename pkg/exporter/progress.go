@@ -0,0 +1,60 @@
+package exporter
+
+import "time"
+
+// Event reports export progress to Options.OnProgress.
+// It is one of the types below.
+type Event interface {
+	event()
+}
+
+// ChannelStarted is sent before a channel is exported.
+// Index starts at 1.
+type ChannelStarted struct {
+	Index int
+	Total int
+	ID    string
+	Name  string
+}
+
+// ChannelDone is sent after a channel is exported or has failed.
+type ChannelDone struct {
+	ID  string
+	Err error
+}
+
+// MessagesFetched is sent after every page of history,
+// with the number of messages of the channel fetched so far.
+type MessagesFetched struct {
+	Count int
+}
+
+// ThreadFetched is sent after the replies of a thread are fetched.
+type ThreadFetched struct {
+	Done  int
+	Total int
+}
+
+// FileDownloaded is sent after an attachment or avatar is saved.
+type FileDownloaded struct {
+	Bytes int64
+}
+
+// RateLimited is sent when a request had to wait for the rate limit.
+type RateLimited struct {
+	Wait time.Duration
+}
+
+func (ChannelStarted) event()  {}
+func (ChannelDone) event()     {}
+func (MessagesFetched) event() {}
+func (ThreadFetched) event()   {}
+func (FileDownloaded) event()  {}
+func (RateLimited) event()     {}
+
+// notify reports export progress, if anyone is listening.
+func (e *Exporter) notify(event Event) {
+	if e.opts.OnProgress != nil {
+		e.opts.OnProgress(event)
+	}
+}
@@ -0,0 +1,363 @@
+package exporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// Kinds of failures in the error report.
+const (
+	FailureChannel = "channel"
+	FailureThread  = "thread"
+	FailureFile    = "file"
+	FailureAvatar  = "avatar"
+)
+
+var (
+	// ErrIncompleteExport is returned by Finish when some items failed.
+	ErrIncompleteExport = fmt.Errorf("export is incomplete")
+
+	errNoPreviousExport = fmt.Errorf("channel was not exported before")
+)
+
+// Failure describes a single item that could not be exported.
+type Failure struct {
+	Kind      string `json:"kind"`
+	Workspace string `json:"workspace,omitempty"`
+	Channel   string `json:"channel,omitempty"`
+	Thread    string `json:"thread,omitempty"`
+	File      string `json:"file,omitempty"`
+	User      string `json:"user,omitempty"`
+	URL       string `json:"url,omitempty"`
+	Error     string `json:"error"`
+}
+
+// Report collects failures so that the export can carry on
+// and the failed items can be re-attempted later with Retry.
+type Report struct {
+	Failures []Failure `json:"failures"`
+}
+
+func (r *Report) add(f Failure) {
+	if r == nil {
+		return
+	}
+
+	r.Failures = append(r.Failures, f)
+}
+
+// ReadReport reads an error report written by a previous export.
+func ReadReport(path string) (*Report, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read report: %w", err)
+	}
+
+	var r Report
+	if err := json.Unmarshal(content, &r); err != nil {
+		return nil, fmt.Errorf("could not unmarshal report: %w", err)
+	}
+
+	return &r, nil
+}
+
+// write saves the report to the path,
+// or removes a stale report if nothing has failed.
+func (r *Report) write(path string) error {
+	if len(r.Failures) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not remove report: %w", err)
+		}
+		return nil
+	}
+
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal report: %w", err)
+	}
+
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		return fmt.Errorf("could not write report: %w", err)
+	}
+
+	return nil
+}
+
+// writeReport saves the error report to the output directory
+// and fails the run if anything could not be exported.
+func (e *Exporter) writeReport() error {
+	path := filepath.Join(e.opts.Output, e.opts.ErrorReport)
+	if err := e.report.write(path); err != nil {
+		return err
+	}
+
+	if len(e.report.Failures) > 0 {
+		return fmt.Errorf("%w: %d item(s) failed, see %s", ErrIncompleteExport, len(e.report.Failures), path)
+	}
+
+	return nil
+}
+
+// Retry re-attempts only the items listed in the previous report,
+// merging threads and files into the existing channel exports.
+// Items that fail again are recorded in the new report,
+// which Finish writes.
+func (e *Exporter) Retry(previous *Report) error {
+	if e.opts.Format != FormatJSON {
+		return errRetryNeedsJSON
+	}
+
+	if e.report == nil {
+		e.report = &Report{}
+	}
+
+	threads := map[string][]string{}  // channel -> thread timestamps
+	files := map[string][]Failure{}   // channel -> files
+	workspaces := map[string]string{} // channel -> workspace
+	channels := map[string]struct{}{}
+
+	for _, f := range previous.Failures {
+		if f.Channel != "" {
+			workspaces[f.Channel] = f.Workspace
+		}
+
+		switch f.Kind {
+		case FailureChannel:
+			channels[f.Channel] = struct{}{}
+		case FailureThread:
+			threads[f.Channel] = append(threads[f.Channel], f.Thread)
+		case FailureFile:
+			files[f.Channel] = append(files[f.Channel], f)
+		case FailureAvatar:
+			slog.Info("Retrying avatar", logging.KeyUser, f.User)
+			if _, err := e.downloadAvatar(f.User, f.URL); err != nil {
+				slog.Warn("Could not download avatar", logging.KeyUser, f.User, logging.Err(err))
+				e.report.add(avatarFailure(f.User, f.URL, err))
+			}
+		}
+	}
+
+	for channel := range channels {
+		slog.Info("Retrying channel", logging.KeyChannel, channel)
+		err := e.setWorkspace(workspaces[channel])
+		if err == nil {
+			err = e.ExportChannel(channel)
+		}
+		if err != nil {
+			slog.Warn("Could not export channel", logging.KeyChannel, channel, logging.Err(err))
+			e.report.add(channelFailure(workspaces[channel], channel, err))
+		}
+
+		// the whole channel was re-exported, including its threads and files
+		delete(threads, channel)
+		delete(files, channel)
+	}
+
+	for channel := range threads {
+		slog.Info(
+			"Retrying threads and files",
+			logging.KeyChannel, channel,
+			"threads", len(threads[channel]),
+			"files", len(files[channel]),
+		)
+		err := e.setWorkspace(workspaces[channel])
+		if err == nil {
+			err = e.retryChannelItems(channel, threads[channel], files[channel])
+		}
+		if err != nil {
+			slog.Warn("Could not retry channel items", logging.KeyChannel, channel, logging.Err(err))
+			e.report.add(channelFailure(workspaces[channel], channel, err))
+		}
+		delete(files, channel)
+	}
+
+	for channel := range files {
+		slog.Info("Retrying files", logging.KeyChannel, channel, "files", len(files[channel]))
+		err := e.setWorkspace(workspaces[channel])
+		if err == nil {
+			err = e.retryChannelItems(channel, nil, files[channel])
+		}
+		if err != nil {
+			slog.Warn("Could not retry channel items", logging.KeyChannel, channel, logging.Err(err))
+			e.report.add(channelFailure(workspaces[channel], channel, err))
+		}
+	}
+
+	return nil
+}
+
+// retryThread adds the thread whose parent is not in the export,
+// like a search match kept in place of its thread, with all replies.
+// Messages of the thread kept at the top level are replaced.
+func (e *Exporter) retryThread(d *structs.Data, channelID, ts string) error {
+	msg, err := e.fullThread(channelID, ts)
+	if err != nil {
+		return err
+	}
+
+	if e.opts.Redactor != nil {
+		e.opts.Redactor.Message(&msg.Message)
+		e.opts.Redactor.Messages(msg.Replies)
+	}
+
+	d.Messages = slices.DeleteFunc(d.Messages, func(m structs.Message) bool {
+		return m.ThreadTimestamp == ts
+	})
+	d.Messages = append(d.Messages, msg)
+
+	// newest first, like conversations.history
+	sort.SliceStable(d.Messages, func(i, j int) bool {
+		return d.Messages[i].Timestamp > d.Messages[j].Timestamp
+	})
+
+	return nil
+}
+
+// retryChannelItems fetches the given threads and files again
+// and merges them into the existing channel export.
+func (e *Exporter) retryChannelItems(channelID string, threads []string, files []Failure) error {
+	redactor := e.opts.Redactor
+
+	outputFilename := e.existingChannelFilename(channelID)
+	if outputFilename == "" {
+		return fmt.Errorf("%w: %q", errNoPreviousExport, channelID)
+	}
+
+	d, err := structs.ReadFile(outputFilename, e.opts.Secret)
+	if err != nil {
+		return fmt.Errorf("could not read file: %w", err)
+	}
+
+	if redactor == nil {
+		for id, user := range d.Users {
+			e.usersCache[id] = user
+		}
+	}
+
+	e.resetSeenUsers()
+	e.files = make(map[string]string)
+
+	for _, ts := range threads {
+		if !slices.ContainsFunc(d.Messages, func(m structs.Message) bool { return m.Timestamp == ts }) {
+			if err := e.retryThread(d, channelID, ts); err != nil {
+				slog.Warn("Could not get thread", logging.KeyChannel, channelID, logging.KeyThread, ts, logging.Err(err))
+				e.report.add(threadFailure(e.workspace, channelID, ts, err))
+			}
+			continue
+		}
+
+		replies, err := e.replies(channelID, ts)
+		if err != nil {
+			slog.Warn("Could not get replies", logging.KeyChannel, channelID, logging.KeyThread, ts, logging.Err(err))
+			e.report.add(threadFailure(e.workspace, channelID, ts, err))
+			continue
+		}
+
+		for i := range d.Messages {
+			if d.Messages[i].Timestamp != ts {
+				continue
+			}
+
+			for _, reply := range replies {
+				e.convertToMsg(reply)
+			}
+			if redactor != nil {
+				redactor.Messages(replies)
+			}
+			d.Messages[i].Replies = replies
+		}
+	}
+
+	// files attached to the re-fetched replies
+	for id, url := range e.files {
+		files = append(files, Failure{Channel: channelID, File: id, URL: url})
+	}
+	e.files = make(map[string]string)
+
+	if redactor != nil {
+		// file contents are dropped from redacted exports
+		files = nil
+	}
+
+	if len(files) > 0 {
+		if err := os.MkdirAll(filepath.Join(e.dir, channelID), 0o755); err != nil {
+			return fmt.Errorf("could not create directory: %w", err)
+		}
+
+		if d.Files == nil {
+			d.Files = make(map[string]string)
+		}
+	}
+
+	for _, f := range files {
+		filename, err := e.downloadFile(channelID, f.File, f.URL)
+		if err != nil {
+			slog.Warn("Could not download file", logging.KeyChannel, channelID, logging.KeyFile, f.File, logging.Err(err))
+			e.report.add(fileFailure(e.workspace, channelID, f.File, f.URL, err))
+			continue
+		}
+
+		d.Files[f.File] = filename
+	}
+
+	users, err := e.users()
+	if err != nil {
+		return fmt.Errorf("could not get users: %w", err)
+	}
+
+	if redactor != nil {
+		users = redactor.Users(users)
+	}
+
+	if d.Users == nil {
+		d.Users = make(map[string]*slack.User)
+	}
+	for id, user := range users {
+		d.Users[id] = user
+	}
+
+	for id, team := range e.userTeams(users) {
+		if d.Teams == nil {
+			d.Teams = make(map[string]*structs.Team)
+		}
+		d.Teams[id] = team
+	}
+
+	content, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("could not marshal messages: %w", err)
+	}
+
+	if err = structs.WriteData(outputFilename, content, e.opts.Secret); err != nil {
+		return fmt.Errorf("could not write messages to file: %w", err)
+	}
+
+	return nil
+}
+
+func channelFailure(workspace, channel string, err error) Failure {
+	return Failure{Kind: FailureChannel, Workspace: workspace, Channel: channel, Error: err.Error()}
+}
+
+func threadFailure(workspace, channel, ts string, err error) Failure {
+	return Failure{Kind: FailureThread, Workspace: workspace, Channel: channel, Thread: ts, Error: err.Error()}
+}
+
+func fileFailure(workspace, channel, id, url string, err error) Failure {
+	return Failure{Kind: FailureFile, Workspace: workspace, Channel: channel, File: id, URL: url, Error: err.Error()}
+}
+
+func avatarFailure(user, url string, err error) Failure {
+	return Failure{Kind: FailureAvatar, User: user, URL: url, Error: err.Error()}
+}
@@ -0,0 +1,226 @@
+package exporter
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// searchPageSize is the largest page search.messages returns.
+const searchPageSize = 100
+
+var (
+	errEmptyQuery     = errors.New("search query is empty")
+	errThreadNotFound = errors.New("thread not found")
+
+	// errAlreadyExported keeps partial exports, of search matches or single
+	// threads, from replacing an export of the whole channel
+	errAlreadyExported = errors.New("channel is already exported into the output directory, use another one")
+)
+
+// ExportSearch exports the messages matching the query, which may use
+// Slack search modifiers like in:#general, from:@user or after:2024-01-01.
+// Matches are grouped by channel and written like exported channels,
+// holding only the matching messages. With threads, the whole thread
+// of every match is exported instead. Channels that are already in the
+// output are not replaced, the export fails instead. Call Finish when done.
+func (e *Exporter) ExportSearch(query string, threads bool) error {
+	if query == "" {
+		return errEmptyQuery
+	}
+
+	e.lookupWorkspaceURL()
+
+	matches, err := e.search(query)
+	if err != nil {
+		return fmt.Errorf("could not search messages: %w", err)
+	}
+
+	// group by channel, in the order of the first match
+	var order []string
+	byChannel := map[string][]slack.SearchMessage{}
+	for _, m := range matches {
+		if _, ok := byChannel[m.Channel.ID]; !ok {
+			order = append(order, m.Channel.ID)
+		}
+		byChannel[m.Channel.ID] = append(byChannel[m.Channel.ID], m)
+	}
+
+	slog.Info("Found messages", "query", query, "matches", len(matches), "channels", len(order))
+
+	if err := e.checkNotExported(order); err != nil {
+		return err
+	}
+
+	for i, channelID := range order {
+		ctx := byChannel[channelID][0].Channel
+		e.notify(ChannelStarted{Index: i + 1, Total: len(order), ID: channelID, Name: cmp.Or(ctx.Name, channelID)})
+
+		err := e.exportMatches(channelID, byChannel[channelID], threads)
+		e.notify(ChannelDone{ID: channelID, Err: err})
+
+		if err != nil {
+			if !e.opts.KeepGoing {
+				return fmt.Errorf("could not export matches in channel %q: %w", cmp.Or(ctx.Name, channelID), err)
+			}
+			slog.Warn("Could not export matches", logging.KeyChannel, channelID, logging.Err(err))
+			e.report.add(channelFailure(e.workspace, channelID, err))
+		}
+	}
+
+	return e.finishChannels()
+}
+
+// search returns all the messages matching the query, newest first.
+func (e *Exporter) search(query string) ([]slack.SearchMessage, error) {
+	params := slack.NewSearchParameters()
+	params.Sort = "timestamp"
+	params.Count = searchPageSize
+
+	var matches []slack.SearchMessage
+	for {
+		if err := e.wait(); err != nil {
+			return nil, fmt.Errorf("rate limit error: %w", err)
+		}
+
+		slog.Debug("Calling Slack API", logging.KeyMethod, "search.messages", "page", params.Page)
+		resp, err := e.api.SearchMessages(query, params)
+		if err != nil {
+			return nil, err
+		}
+
+		matches = append(matches, resp.Matches...)
+		e.notify(MessagesFetched{Count: len(matches)})
+
+		if resp.Paging.Page >= resp.Paging.Pages {
+			break
+		}
+		params.Page = resp.Paging.Page + 1
+	}
+
+	return matches, nil
+}
+
+// exportMatches writes the matches in a single channel.
+func (e *Exporter) exportMatches(channelID string, matches []slack.SearchMessage, threads bool) error {
+	channelInfo, err := e.ChannelInfo(channelID)
+	if err != nil {
+		return fmt.Errorf("could not get channel %q info: %w", channelID, err)
+	}
+
+	var msgs []structs.Message
+	if threads {
+		msgs = e.matchThreads(channelID, matches)
+	} else {
+		for _, m := range matches {
+			msgs = append(msgs, e.convertToMsg(searchToMessage(m)))
+		}
+	}
+
+	// newest first, like conversations.history
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].Timestamp > msgs[j].Timestamp
+	})
+
+	return e.writeChannel(channelInfo, msgs, nil)
+}
+
+// matchThreads fetches the thread of every match, once per thread.
+func (e *Exporter) matchThreads(channelID string, matches []slack.SearchMessage) []structs.Message {
+	var msgs []structs.Message
+	seen := map[string]bool{}
+
+	for _, m := range matches {
+		ts := cmp.Or(threadTimestamp(m.Permalink), m.Timestamp)
+		if seen[ts] {
+			continue
+		}
+		seen[ts] = true
+
+		msg, err := e.fullThread(channelID, ts)
+		if err != nil {
+			slog.Warn(
+				"Could not get thread, keeping the match only",
+				logging.KeyChannel, channelID,
+				logging.KeyThread, ts,
+				logging.Err(err),
+			)
+			e.report.add(threadFailure(e.workspace, channelID, ts, err))
+			msg = e.convertToMsg(searchToMessage(m))
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	return msgs
+}
+
+// fullThread returns the parent message with all its replies.
+func (e *Exporter) fullThread(channelID, ts string) (structs.Message, error) {
+	all, err := e.thread(channelID, ts)
+	if err != nil {
+		return structs.Message{}, err
+	}
+
+	var (
+		parent  *slack.Message
+		replies []slack.Message
+	)
+	for i := range all {
+		if all[i].Timestamp == ts {
+			parent = &all[i]
+			continue
+		}
+		replies = append(replies, all[i])
+	}
+
+	if parent == nil {
+		return structs.Message{}, fmt.Errorf("%w: %s", errThreadNotFound, ts)
+	}
+
+	msg := e.convertToMsg(*parent)
+	for _, reply := range replies {
+		// collects the users and files of the replies
+		e.convertToMsg(reply)
+	}
+	msg.Replies = replies
+
+	return msg, nil
+}
+
+// searchToMessage converts a match to a message.
+// Matches have no files, reactions or reply counts.
+func searchToMessage(m slack.SearchMessage) slack.Message {
+	return slack.Message{Msg: slack.Msg{
+		Type:            m.Type,
+		Channel:         m.Channel.ID,
+		User:            m.User,
+		Username:        m.Username,
+		Timestamp:       m.Timestamp,
+		ThreadTimestamp: threadTimestamp(m.Permalink),
+		Text:            m.Text,
+		Blocks:          m.Blocks,
+		Attachments:     m.Attachments,
+		Permalink:       m.Permalink,
+	}}
+}
+
+// threadTimestamp returns the thread of a reply from its permalink,
+// like https://example.slack.com/archives/C0000000000/p1700000000000200?thread_ts=1700000000.000100,
+// or an empty string for messages that are not replies.
+func threadTimestamp(permalink string) string {
+	u, err := url.Parse(permalink)
+	if err != nil {
+		return ""
+	}
+
+	return u.Query().Get("thread_ts")
+}
@@ -0,0 +1,528 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+var (
+	errChannelRequired      = fmt.Errorf("argument 'channel' is required")
+	errNoContentDisposition = fmt.Errorf("no content-disposition header")
+	errBadStatus            = fmt.Errorf("bad status code")
+)
+
+// API is the part of the Slack Web API the exporter uses.
+// *slack.Client implements it; tests and embedders can provide their own.
+type API interface {
+	AuthTest() (*slack.AuthTestResponse, error)
+	GetConversations(params *slack.GetConversationsParameters) ([]slack.Channel, string, error)
+	GetConversationInfo(input *slack.GetConversationInfoInput) (*slack.Channel, error)
+	GetConversationHistory(params *slack.GetConversationHistoryParameters) (*slack.GetConversationHistoryResponse, error)
+	GetConversationReplies(params *slack.GetConversationRepliesParameters) ([]slack.Message, bool, string, error)
+	GetUserInfo(user string) (*slack.User, error)
+	ListTeams(params slack.ListTeamsParameters) ([]slack.Team, string, error)
+	GetOtherTeamInfo(team string) (*slack.TeamInfo, error)
+	SearchMessages(query string, params slack.SearchParameters) (*slack.SearchMessages, error)
+}
+
+// wait blocks until the rate limiter allows the next request
+// and reports noticeable waits.
+func (e *Exporter) wait() error {
+	start := time.Now()
+	if err := e.limiter.Wait(e.ctx); err != nil {
+		return err
+	}
+
+	if waited := time.Since(start); waited > 10*time.Millisecond {
+		e.notify(RateLimited{Wait: waited})
+	}
+
+	return nil
+}
+
+// WorkspaceURL returns the URL of the workspace the token belongs to,
+// like https://example.slack.com/.
+func (e *Exporter) WorkspaceURL() (string, error) {
+	resp, err := e.authTest()
+	if err != nil {
+		return "", err
+	}
+
+	return resp.URL, nil
+}
+
+// Channels lists the channels of the given types,
+// like "public_channel", "private_channel", "mpim" and "im".
+func (e *Exporter) Channels(types []string) ([]slack.Channel, error) {
+	return e.channels("", types)
+}
+
+// channels lists the channels of the given types in the workspace,
+// or in the default workspace of the token if teamID is empty.
+func (e *Exporter) channels(teamID string, types []string) ([]slack.Channel, error) {
+	var allChannels []slack.Channel
+	cursor := ""
+	for {
+		err := e.wait()
+		if err != nil {
+			return nil, fmt.Errorf("rate limit error: %w", err)
+		}
+
+		slog.Debug("Calling Slack API", logging.KeyMethod, "conversations.list", "types", types, "workspace", teamID, "cursor", cursor)
+		resp, next, err := e.api.GetConversations(&slack.GetConversationsParameters{
+			Types:  types,
+			Limit:  999,
+			Cursor: cursor,
+			TeamID: teamID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not get public channels: %w", err)
+		}
+
+		allChannels = append(allChannels, resp...)
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	return allChannels, nil
+}
+
+// users returns the users who have posted or were mentioned
+// in the current channel. Users that users.info can't find,
+// like people from other organizations, are kept as seen in messages.
+func (e *Exporter) users() (map[string]*slack.User, error) {
+	result := map[string]*slack.User{}
+
+	for user := range e.seenUsers {
+		if user == "" {
+			continue
+		}
+		if u, ok := e.usersCache[user]; ok {
+			result[user] = u
+			continue
+		}
+
+		u, err := e.userWithRetry(user)
+		if err != nil {
+			if !strings.Contains(err.Error(), "user_not_found") {
+				return nil, fmt.Errorf("could not get user %q: %w", user, err)
+			}
+
+			slog.Warn("User was not found, keeping the profile from messages", logging.KeyUser, user)
+			u = e.placeholderUser(user)
+		}
+
+		e.usersCache[user] = u
+		result[user] = u
+	}
+
+	return result, nil
+}
+
+func (e *Exporter) userWithRetry(user string) (*slack.User, error) {
+	err := e.wait()
+	if err != nil {
+		return nil, fmt.Errorf("rate limit error: %w", err)
+	}
+
+	slog.Debug("Calling Slack API", logging.KeyMethod, "users.info", logging.KeyUser, user)
+	u, err := e.api.GetUserInfo(user)
+	if err != nil {
+		var rateLimitErr *slack.RateLimitedError
+		if errors.As(err, &rateLimitErr) {
+			slog.Warn(
+				"Rate limit exceeded",
+				logging.KeyMethod, "users.info",
+				logging.KeyUser, user,
+				"retry_after", rateLimitErr.RetryAfter,
+			)
+			e.notify(RateLimited{Wait: rateLimitErr.RetryAfter})
+			time.Sleep(rateLimitErr.RetryAfter)
+			return e.userWithRetry(user)
+		}
+		return nil, fmt.Errorf("%q: %w", user, err)
+	}
+
+	return u, nil
+}
+
+// ChannelInfo returns information about the channel, such as the name.
+func (e *Exporter) ChannelInfo(channel string) (*slack.Channel, error) {
+	if channel == "" {
+		return nil, errChannelRequired
+	}
+
+	if err := e.wait(); err != nil {
+		return nil, fmt.Errorf("rate limit error: %w", err)
+	}
+
+	slog.Debug("Calling Slack API", logging.KeyMethod, "conversations.info", logging.KeyChannel, channel)
+	c, err := e.api.GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: channel})
+	if err != nil {
+		return nil, err
+	}
+
+	e.resetSeenUsers()
+	if c.User != "" {
+		e.seenUsers[c.User] = struct{}{}
+	}
+
+	return c, nil
+}
+
+// resetSeenUsers forgets users collected for the previous channel.
+func (e *Exporter) resetSeenUsers() {
+	e.seenUsers = make(map[string]interface{})
+}
+
+// messages returns the messages in the channel, with their replies:
+// all of them, or the ones since oldest if set.
+func (e *Exporter) messages(channel, oldest string) ([]structs.Message, error) {
+	if channel == "" {
+		return nil, errChannelRequired
+	}
+
+	var allMessages []slack.Message
+
+	cursor := ""
+	for {
+		err := e.wait()
+		if err != nil {
+			return nil, fmt.Errorf("rate limit error: %w", err)
+		}
+
+		slog.Debug("Calling Slack API", logging.KeyMethod, "conversations.history", logging.KeyChannel, channel, "cursor", cursor)
+		resp, err := e.api.GetConversationHistory(&slack.GetConversationHistoryParameters{
+			ChannelID: channel,
+			Limit:     999,
+			Cursor:    cursor,
+			Oldest:    oldest,
+			Inclusive: oldest != "",
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		allMessages = append(allMessages, resp.Messages...)
+		e.notify(MessagesFetched{Count: len(allMessages)})
+
+		if resp.ResponseMetaData.NextCursor == "" {
+			break
+		}
+
+		cursor = resp.ResponseMetaData.NextCursor
+	}
+
+	threads := 0
+	for _, msg := range allMessages {
+		if msg.ReplyCount > 0 {
+			threads++
+		}
+	}
+
+	threadsDone := 0
+	convertedMessages := make([]structs.Message, 0, len(allMessages))
+	for _, msg := range allMessages {
+		var replies []slack.Message
+		var err error
+
+		if msg.ReplyCount > 0 {
+			replies, err = e.replies(channel, msg.Timestamp)
+			if err != nil {
+				slog.Warn(
+					"Could not get replies",
+					logging.KeyMethod, "conversations.replies",
+					logging.KeyChannel, channel,
+					logging.KeyThread, msg.Timestamp,
+					logging.Err(err),
+				)
+				e.report.add(threadFailure(e.workspace, channel, msg.Timestamp, err))
+			}
+			threadsDone++
+			e.notify(ThreadFetched{Done: threadsDone, Total: threads})
+		}
+
+		convertedMsg := e.convertToMsg(msg)
+		convertedMsg.Replies = replies
+		convertedMessages = append(convertedMessages, convertedMsg)
+	}
+
+	return convertedMessages, nil
+}
+
+// replies returns all the replies to a message.
+func (e *Exporter) replies(channel, messageID string) ([]slack.Message, error) {
+	allReplies, err := e.thread(channel, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filter out reply which matches the parent message
+	filterFn := func(replies []slack.Message, parentId string) (ret []slack.Message) {
+		for _, r := range replies {
+			if r.Timestamp != parentId {
+				ret = append(ret, r)
+			}
+		}
+		return ret
+	}
+	filteredReplies := filterFn(allReplies, messageID)
+
+	// Add attachments to slice
+	for _, reply := range filteredReplies {
+		if reply.Files != nil {
+			for _, file := range reply.Files {
+				if file.URLPrivateDownload == "" {
+					continue
+				}
+				e.files[file.ID] = file.URLPrivateDownload
+			}
+		}
+	}
+
+	return filteredReplies, nil
+}
+
+// thread returns the message with all its replies, the message first.
+func (e *Exporter) thread(channel, messageID string) ([]slack.Message, error) {
+	if channel == "" {
+		return nil, errChannelRequired
+	}
+
+	var allReplies []slack.Message
+
+	cursor := ""
+	for {
+		err := e.wait()
+		if err != nil {
+			return nil, fmt.Errorf("rate limit error: %w", err)
+		}
+
+		slog.Debug(
+			"Calling Slack API",
+			logging.KeyMethod, "conversations.replies",
+			logging.KeyChannel, channel,
+			logging.KeyThread, messageID,
+			"cursor", cursor,
+		)
+		msgs, _, nextCursor, err := e.api.GetConversationReplies(&slack.GetConversationRepliesParameters{
+			ChannelID: channel,
+			Limit:     999,
+			Cursor:    cursor,
+			Timestamp: messageID,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		allReplies = append(allReplies, msgs...)
+
+		if nextCursor == "" {
+			break
+		}
+
+		cursor = nextCursor
+	}
+
+	return allReplies, nil
+}
+
+func (e *Exporter) convertToMsg(message slack.Message) structs.Message {
+	e.seenUsers[message.User] = nil
+
+	for _, block := range message.Blocks.BlockSet {
+		switch block.BlockType() {
+		case slack.MBTRichText:
+			e.processRichTextElements(block.(*slack.RichTextBlock).Elements)
+		}
+	}
+
+	if message.Files != nil {
+		for _, file := range message.Files {
+			if file.URLPrivateDownload == "" {
+				continue
+			}
+			e.files[file.ID] = file.URLPrivateDownload
+		}
+	}
+
+	return structs.Message{
+		Message: message,
+	}
+}
+
+func (e *Exporter) processRichTextElements(elements []slack.RichTextElement) {
+	for _, element := range elements {
+		switch element.RichTextElementType() {
+		case slack.RTESection:
+			e.processRichTextSectionElements(element.(*slack.RichTextSection).Elements)
+		case slack.RTEQuote:
+			e.processRichTextSectionElements(element.(*slack.RichTextQuote).Elements)
+		case slack.RTEList:
+			e.processRichTextElements(element.(*slack.RichTextList).Elements)
+		}
+	}
+}
+
+func (e *Exporter) processRichTextSectionElements(elements []slack.RichTextSectionElement) {
+	for _, rtEelement := range elements {
+		switch rtEelement.RichTextSectionElementType() {
+		case slack.RTSEUser:
+			e.seenUsers[rtEelement.(*slack.RichTextSectionUserElement).UserID] = nil
+		}
+	}
+}
+
+// downloadFiles downloads all the files in the channel.
+func (e *Exporter) downloadFiles(channelID string) (map[string]string, error) {
+	result := make(map[string]string)
+
+	// create directory for files
+	err := os.MkdirAll(filepath.Join(e.dir, channelID), 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create directory: %w", err)
+	}
+
+	for id, url := range e.files {
+		filename, err := e.downloadFile(channelID, id, url)
+		if err != nil {
+			slog.Warn("Could not download file", logging.KeyChannel, channelID, logging.KeyFile, id, logging.Err(err))
+			e.report.add(fileFailure(e.workspace, channelID, id, url, err))
+		}
+
+		result[id] = filename
+	}
+
+	return result, nil
+}
+
+func (e *Exporter) downloadFile(path, id, fileURL string) (string, error) {
+	req, err := http.NewRequestWithContext(e.ctx, http.MethodGet, fileURL, http.NoBody)
+	if err != nil {
+		return "", fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+e.token)
+
+	err = e.wait()
+	if err != nil {
+		return "", fmt.Errorf("rate limit error: %w", err)
+	}
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not send request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %d", errBadStatus, resp.StatusCode)
+	}
+
+	// read content-disposition header
+	disposition := resp.Header.Get("Content-Disposition")
+	if disposition == "" {
+		return "", errNoContentDisposition
+	}
+
+	// extract filename from content-disposition header
+	filename := strings.TrimPrefix(disposition, "attachment; filename=\"")
+	// remove everything after ";
+	filename = strings.Split(filename, "\";")[0]
+
+	// if filename is empty, use the id
+	if filename == "" {
+		filename = id
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("could not read body: %w", err)
+	}
+
+	// adding id prefix to filename to avoid collisions (like a few files named image.png)
+	err = structs.WriteFile(filepath.Join(e.dir, path, id+"-"+filename), content, e.opts.Secret)
+	if err != nil {
+		return "", fmt.Errorf("could not write file: %w", err)
+	}
+
+	e.notify(FileDownloaded{Bytes: int64(len(content))})
+
+	return filename, nil
+}
+
+func (e *Exporter) downloadAvatars() error {
+	err := os.MkdirAll(filepath.Join(e.opts.Output, "avatars"), 0o755)
+	if err != nil {
+		return fmt.Errorf("could not create avatars directory: %w", err)
+	}
+
+	for _, user := range e.usersCache {
+		if user.Profile.Image512 != "" {
+			n, err := e.downloadAvatar(user.ID, user.Profile.Image512)
+			if err != nil {
+				if !e.opts.KeepGoing {
+					return fmt.Errorf("could not download avatar: %w", err)
+				}
+				slog.Warn("Could not download avatar", logging.KeyUser, user.ID, logging.Err(err))
+				e.report.add(avatarFailure(user.ID, user.Profile.Image512, err))
+				continue
+			}
+			e.notify(FileDownloaded{Bytes: n})
+		}
+	}
+
+	return nil
+}
+
+func (e *Exporter) downloadAvatar(id, fileURL string) (int64, error) {
+	req, err := http.NewRequestWithContext(e.ctx, http.MethodGet, fileURL, http.NoBody)
+	if err != nil {
+		return 0, fmt.Errorf("could not create request: %w", err)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("could not send request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%w: %d", errBadStatus, resp.StatusCode)
+	}
+
+	filename := filepath.Join(e.opts.Output, "avatars", id+".png")
+	file, err := structs.Create(filename, e.opts.Secret)
+	if err != nil {
+		return 0, fmt.Errorf("could not create file: %w", err)
+	}
+
+	n, err := io.Copy(file, resp.Body)
+	if err != nil {
+		file.Close()
+		return 0, fmt.Errorf("could not write file: %w", err)
+	}
+
+	// the last chunk of encrypted files is written on close
+	if err := file.Close(); err != nil {
+		return 0, fmt.Errorf("could not write file: %w", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,116 @@
+package exporter
+
+import (
+	"log/slog"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// authTest returns the workspace the token belongs to,
+// calling auth.test once, and failing the same way afterwards.
+func (e *Exporter) authTest() (*slack.AuthTestResponse, error) {
+	if e.auth != nil || e.authErr != nil {
+		return e.auth, e.authErr
+	}
+
+	if err := e.wait(); err != nil {
+		return nil, err
+	}
+
+	slog.Debug("Calling Slack API", logging.KeyMethod, "auth.test")
+	e.auth, e.authErr = e.api.AuthTest()
+	return e.auth, e.authErr
+}
+
+// placeholderUser returns the user as seen in messages, for users
+// that users.info can't find, so that they are kept in the export.
+func (e *Exporter) placeholderUser(id string) *slack.User {
+	if e.opts.Profiles != nil {
+		if u, ok := e.opts.Profiles.User(id); ok {
+			return u
+		}
+	}
+
+	return &slack.User{ID: id}
+}
+
+// userTeams returns the teams the users belong to,
+// and whether they are external organizations.
+func (e *Exporter) userTeams(users map[string]*slack.User) map[string]*structs.Team {
+	if len(users) == 0 {
+		return nil
+	}
+
+	home, err := e.authTest()
+	if err != nil {
+		// without the own workspace nobody can be told apart
+		slog.Debug("Could not get own workspace, teams are left out", logging.Err(err))
+		return nil
+	}
+
+	result := map[string]*structs.Team{}
+	for _, u := range users {
+		if u.TeamID == "" {
+			continue
+		}
+
+		team, ok := e.teamsCache[u.TeamID]
+		if !ok {
+			team = e.team(u, home)
+			e.teamsCache[u.TeamID] = team
+		}
+
+		result[u.TeamID] = team
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+
+	return result
+}
+
+// team describes the team of the user, looking up
+// the names of external organizations with team.info.
+func (e *Exporter) team(u *slack.User, home *slack.AuthTestResponse) *structs.Team {
+	team := &structs.Team{ID: u.TeamID}
+
+	switch {
+	case u.TeamID == home.TeamID:
+		team.Name = home.Team
+		return team
+	case u.TeamID == home.EnterpriseID:
+		return team
+	}
+
+	if t, ok := e.teams[u.TeamID]; ok {
+		// a workspace of the same Enterprise Grid organization
+		team.Name = t.Name
+		team.Domain = t.Domain
+		return team
+	}
+
+	if home.EnterpriseID != "" && u.Enterprise.EnterpriseID == home.EnterpriseID {
+		return team
+	}
+
+	team.External = true
+
+	if err := e.wait(); err != nil {
+		return team
+	}
+
+	slog.Debug("Calling Slack API", logging.KeyMethod, "team.info", "team", u.TeamID)
+	info, err := e.api.GetOtherTeamInfo(u.TeamID)
+	if err != nil {
+		slog.Warn("Could not get external team", "team", u.TeamID, logging.Err(err))
+		return team
+	}
+
+	team.Name = info.Name
+	team.Domain = info.Domain
+	return team
+}
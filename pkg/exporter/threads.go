@@ -0,0 +1,143 @@
+package exporter
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+var (
+	errBadThreadRef = errors.New("expected a message permalink or channel:ts")
+
+	// threadTS matches message timestamps, like 1700000000.000100
+	threadTS = regexp.MustCompile(`^\d+\.\d+$`)
+	// permalinkTS matches the timestamp in permalinks, without the dot
+	permalinkTS = regexp.MustCompile(`^p\d{7,}$`)
+)
+
+// ThreadRef identifies a thread by its channel and the timestamp
+// of its parent message.
+type ThreadRef struct {
+	Channel   string
+	Timestamp string
+}
+
+// ParseThreadRef parses a message permalink, like
+// https://example.slack.com/archives/C0000000000/p1700000000000100,
+// or a channel:ts pair, like C0000000000:1700000000.000100.
+// Permalinks of replies refer to the thread they are in.
+func ParseThreadRef(s string) (ThreadRef, error) {
+	if !strings.Contains(s, "://") {
+		channel, ts, ok := strings.Cut(s, ":")
+		if !ok || channel == "" || !threadTS.MatchString(ts) {
+			return ThreadRef{}, fmt.Errorf("%w: %q", errBadThreadRef, s)
+		}
+		return ThreadRef{Channel: channel, Timestamp: ts}, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return ThreadRef{}, fmt.Errorf("%w: %q: %w", errBadThreadRef, s, err)
+	}
+
+	// /archives/<channel>/p<ts without the dot>
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "archives" || parts[1] == "" || !permalinkTS.MatchString(parts[2]) {
+		return ThreadRef{}, fmt.Errorf("%w: %q", errBadThreadRef, s)
+	}
+
+	digits := parts[2][1:]
+	ts := cmp.Or(u.Query().Get("thread_ts"), digits[:len(digits)-6]+"."+digits[len(digits)-6:])
+	if !threadTS.MatchString(ts) {
+		return ThreadRef{}, fmt.Errorf("%w: %q", errBadThreadRef, s)
+	}
+
+	return ThreadRef{
+		Channel:   cmp.Or(u.Query().Get("cid"), parts[1]),
+		Timestamp: ts,
+	}, nil
+}
+
+// ExportThreads exports single threads with the parent message
+// and all replies. Threads are grouped by channel and written
+// like exported channels, holding only these threads. Channels that are
+// already in the output are not replaced, the export fails instead.
+// Call Finish when done.
+func (e *Exporter) ExportThreads(refs []ThreadRef) error {
+	e.lookupWorkspaceURL()
+
+	var order []string
+	byChannel := map[string][]string{}
+	for _, ref := range refs {
+		if _, ok := byChannel[ref.Channel]; !ok {
+			order = append(order, ref.Channel)
+		}
+		byChannel[ref.Channel] = append(byChannel[ref.Channel], ref.Timestamp)
+	}
+
+	if err := e.checkNotExported(order); err != nil {
+		return err
+	}
+
+	for i, channelID := range order {
+		e.notify(ChannelStarted{Index: i + 1, Total: len(order), ID: channelID, Name: channelID})
+
+		err := e.exportThreads(channelID, byChannel[channelID])
+		e.notify(ChannelDone{ID: channelID, Err: err})
+
+		if err != nil {
+			if !e.opts.KeepGoing {
+				return fmt.Errorf("could not export threads in channel %q: %w", channelID, err)
+			}
+			slog.Warn("Could not export threads", logging.KeyChannel, channelID, logging.Err(err))
+			e.report.add(channelFailure(e.workspace, channelID, err))
+		}
+	}
+
+	return e.finishChannels()
+}
+
+// exportThreads writes the threads of a single channel.
+func (e *Exporter) exportThreads(channelID string, timestamps []string) error {
+	channelInfo, err := e.ChannelInfo(channelID)
+	if err != nil {
+		return fmt.Errorf("could not get channel %q info: %w", channelID, err)
+	}
+
+	var msgs []structs.Message
+	seen := map[string]bool{}
+	for i, ts := range timestamps {
+		if seen[ts] {
+			continue
+		}
+		seen[ts] = true
+
+		msg, err := e.fullThread(channelID, ts)
+		if err != nil {
+			if !e.opts.KeepGoing {
+				return fmt.Errorf("could not get thread %s: %w", ts, err)
+			}
+			slog.Warn("Could not get thread", logging.KeyChannel, channelID, logging.KeyThread, ts, logging.Err(err))
+			e.report.add(threadFailure(e.workspace, channelID, ts, err))
+			continue
+		}
+
+		msgs = append(msgs, msg)
+		e.notify(ThreadFetched{Done: i + 1, Total: len(timestamps)})
+	}
+
+	// newest first, like conversations.history
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].Timestamp > msgs[j].Timestamp
+	})
+
+	return e.writeChannel(channelInfo, msgs, nil)
+}
@@ -0,0 +1,149 @@
+package exporter
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// ListWorkspaces lists the workspaces of the Enterprise Grid organization
+// the token can access. Tokens outside Enterprise Grid get an error.
+func (e *Exporter) ListWorkspaces() ([]slack.Team, error) {
+	var teams []slack.Team
+	cursor := ""
+	for {
+		if err := e.wait(); err != nil {
+			return nil, fmt.Errorf("rate limit error: %w", err)
+		}
+
+		slog.Debug("Calling Slack API", logging.KeyMethod, "auth.teams.list", "cursor", cursor)
+		resp, next, err := e.api.ListTeams(slack.ListTeamsParameters{Cursor: cursor})
+		if err != nil {
+			return nil, fmt.Errorf("could not list workspaces: %w", err)
+		}
+
+		teams = append(teams, resp...)
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	return teams, nil
+}
+
+// WorkspaceTargets lists the channels of the given types in each workspace,
+// to be exported into a subdirectory per workspace.
+// Channels shared between workspaces are listed once,
+// in the first workspace that has them.
+func (e *Exporter) WorkspaceTargets(teams []slack.Team, types []string) ([]Target, error) {
+	var targets []Target
+	seen := map[string]bool{}
+
+	for _, team := range teams {
+		e.teams[team.ID] = team
+
+		list, err := e.channels(team.ID, types)
+		if err != nil {
+			return nil, fmt.Errorf("could not get channels of workspace %q: %w", cmp.Or(team.Domain, team.ID), err)
+		}
+
+		for i, channel := range list {
+			if seen[channel.ID] {
+				slog.Debug("Skipping shared channel listed in another workspace", logging.KeyChannel, channel.ID, "workspace", team.ID)
+				continue
+			}
+			seen[channel.ID] = true
+
+			targets = append(targets, Target{
+				ID:        channel.ID,
+				Name:      cmp.Or(channel.Name, channel.ID),
+				Info:      &list[i],
+				Workspace: team.ID,
+			})
+		}
+	}
+
+	return targets, nil
+}
+
+// setWorkspace directs the following channels into the subdirectory
+// of the workspace, or into the output directory if it is empty.
+func (e *Exporter) setWorkspace(teamID string) error {
+	e.workspace = teamID
+	e.dir = filepath.Join(e.opts.Output, teamID)
+
+	if err := os.MkdirAll(e.dir, 0o755); err != nil {
+		return fmt.Errorf("could not create workspace directory: %w", err)
+	}
+
+	return nil
+}
+
+// addToWorkspace records the exported channel in the workspace index.
+func (e *Exporter) addToWorkspace(channelID string) {
+	if e.workspace == "" {
+		return
+	}
+
+	if e.workspaceChannels[e.workspace] == nil {
+		e.workspaceChannels[e.workspace] = map[string]bool{}
+	}
+	e.workspaceChannels[e.workspace][channelID] = true
+}
+
+// writeWorkspaces writes the workspace index, merged with the index
+// of a previous export into the same directory.
+func (e *Exporter) writeWorkspaces() error {
+	path := filepath.Join(e.opts.Output, structs.WorkspacesName)
+
+	index, err := structs.ReadWorkspaces(os.DirFS(e.opts.Output))
+	if err != nil {
+		return err
+	}
+	if index == nil {
+		index = &structs.Workspaces{}
+	}
+
+	for teamID, channels := range e.workspaceChannels {
+		i := index.Find(teamID)
+		if i < 0 {
+			index.Workspaces = append(index.Workspaces, structs.Workspace{ID: teamID})
+			i = len(index.Workspaces) - 1
+		}
+
+		w := &index.Workspaces[i]
+		if team, ok := e.teams[teamID]; ok {
+			w.Name = team.Name
+			w.Domain = team.Domain
+		}
+
+		for channelID := range channels {
+			if index.WorkspaceOf(channelID) == "" {
+				w.Channels = append(w.Channels, channelID)
+			}
+		}
+	}
+
+	index.Sort()
+
+	content, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal workspaces: %w", err)
+	}
+
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		return fmt.Errorf("could not write workspaces: %w", err)
+	}
+
+	return nil
+}
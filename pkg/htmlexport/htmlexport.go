@@ -0,0 +1,672 @@
+// Package htmlexport renders exported channels as HTML pages.
+package htmlexport
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	_ "embed"
+
+	"github.com/enescakir/emoji"
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/reader"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// Options configure the conversion.
+type Options struct {
+	// EmojiDir is the directory with custom emoji, downloaded by the emoji tool.
+	EmojiDir string
+	// SkipArchived leaves out archived channels.
+	SkipArchived bool
+	// Secret decrypts encrypted input files.
+	Secret *structs.Secret
+}
+
+var (
+	errChannelIsArchived = fmt.Errorf("channel is archived")
+	errNoMessages        = fmt.Errorf("no messages")
+	errNoChannels        = fmt.Errorf("no exported channels found")
+)
+
+//go:embed template.html
+var tmpl string
+
+//go:embed index.html
+var index string
+
+// Converter renders exports as HTML. A Converter can convert exports
+// one after another, but is not safe for concurrent use.
+type Converter struct {
+	opts       Options
+	slackEmoji structs.EmojiMap
+
+	// channelDirs are the directories of the channels in the export by ID,
+	// set for channels of Enterprise Grid workspaces
+	channelDirs map[string]string
+
+	// copyAssets is set when attachments, avatars and emoji are copied
+	// next to the HTML files
+	copyAssets bool
+}
+
+// New returns a converter with the options.
+func New(o Options) *Converter {
+	return &Converter{opts: o}
+}
+
+func (c *Converter) funcs() template.FuncMap {
+	return template.FuncMap{
+		"lookupUser": lookupUser,
+		"username":   structs.UserName,
+		"avatar": func(user *slack.User) string {
+			if user == nil {
+				return ""
+			}
+			return path.Join("avatars", user.ID+".png")
+		},
+		"title": title,
+		"sameMessage": func(a, b structs.Message) bool {
+			return a.SameContext(b)
+		},
+		"usersList": func(ids []string, users map[string]*slack.User) string {
+			names := make([]string, 0, len(ids))
+
+			for _, id := range ids {
+				names = append(names, structs.UserName(lookupUser(id, users)))
+			}
+
+			return strings.Join(names, ", ")
+		},
+		"sameSlackMessage": func(a, b slack.Message) bool {
+			ma := structs.Message{Message: a}
+			return ma.SameContext(structs.Message{Message: b})
+		},
+		"formatTime": func(ts string) string {
+			t, err := structs.ParseTimestamp(ts)
+			if err != nil {
+				slog.Warn("Could not parse time", "ts", ts, logging.Err(err))
+				return ts
+			}
+
+			return t.Local().Format(time.ANSIC)
+		},
+		"formatDate": func(t time.Time) string {
+			return t.Local().Format(time.ANSIC)
+		},
+		"emoji":   c.emojiParse,
+		"replace": strings.ReplaceAll,
+		"format": func(blocks slack.Blocks, users map[string]*slack.User) template.HTML {
+			sb := &strings.Builder{}
+			for _, block := range blocks.BlockSet {
+				switch block.BlockType() {
+				case slack.MBTRichText:
+					sb.WriteString(
+						c.processRichTextElements(block.(*slack.RichTextBlock).Elements, users),
+					)
+				case slack.MBTSection:
+					sb.WriteString(block.(*slack.SectionBlock).Text.Text)
+				}
+			}
+
+			return template.HTML(sb.String()) // #nosec G203
+		},
+		"attachment": c.attachment,
+	}
+}
+
+func (c *Converter) attachment(file slack.File, files map[string]string, channel slack.Channel) template.HTML {
+	filename, ok := files[file.ID]
+	if !ok {
+		url := file.URLPrivateDownload
+		if url == "" {
+			url = file.URLPrivate
+		}
+		return template.HTML(fmt.Sprintf("<a href=%q>%s</a>", url, file.Title)) // #nosec G203
+	}
+
+	// url-encode filename (account for \u202f symbol)
+	link := path.Join(c.channelDirs[channel.ID], channel.ID, url.PathEscape(file.ID+"-"+filename))
+
+	switch file.Filetype {
+	case "png", "jpg", "gif":
+		w, h := maxLength(file.OriginalW, file.OriginalH, 550, 550)
+		return template.HTML( // #nosec G203
+			fmt.Sprintf(
+				"<img loading=\"lazy\" src=%q alt=%q class=\"attachment\" width=\"%d\" height=\"%d\"/>",
+				link,
+				file.Title,
+				w, h,
+			),
+		)
+	case "mov", "mp4":
+		return template.HTML( // #nosec G203
+			fmt.Sprintf(
+				"<video controls preload=\"none\" src=%q alt=%q class=\"attachment\"/>",
+				link,
+				file.Title,
+			),
+		)
+
+	default:
+		return template.HTML( // #nosec G203
+			fmt.Sprintf(
+				"<a href=%q download=%q>%s</a>",
+				link,
+				file.Name,
+				file.Title,
+			),
+		)
+	}
+}
+
+// Convert renders the input JSON file, directory or bundle as HTML
+// into the output file or directory.
+//
+// Attachments, avatars and custom emoji are linked relative to the HTML files.
+// Unless the output is the directory of the input, they are copied next
+// to the HTML files, decrypted if the input is encrypted.
+func (c *Converter) Convert(input, output string) error {
+	c.slackEmoji = nil
+	c.channelDirs = map[string]string{}
+	c.copyAssets = false
+
+	var err error
+	if c.opts.EmojiDir != "" {
+		c.slackEmoji, err = structs.LoadEmoji(c.opts.EmojiDir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				slog.Info("Emoji file not found, skipping", logging.KeyPath, c.opts.EmojiDir)
+			} else {
+				return fmt.Errorf("could not load emoji: %w", err)
+			}
+		}
+	}
+
+	t, err := template.New("template").Funcs(c.funcs()).Parse(tmpl)
+	if err != nil {
+		return fmt.Errorf("could not parse template: %w", err)
+	}
+
+	// check if input is a file, a directory or a bundle
+	info, err := os.Stat(input)
+	if err != nil {
+		return fmt.Errorf("could not get file info: %w", err)
+	}
+
+	export, err := reader.Open(input, reader.WithSecret(c.opts.Secret))
+	if err != nil {
+		return err
+	}
+	defer export.Close()
+
+	single := !info.IsDir() && !structs.IsBundle(input)
+
+	// the directories the assets are in and the HTML files link them from
+	inputDir, outputDir := input, output
+	if single {
+		inputDir, outputDir = filepath.Dir(input), filepath.Dir(output)
+	}
+
+	switch {
+	case structs.IsBundle(input) || !sameDir(inputDir, outputDir):
+		c.copyAssets = true
+	case c.opts.Secret != nil:
+		slog.Warn("Attachments and avatars are linked as they are in the export, write the HTML into another directory to decrypt them", logging.KeyPath, outputDir)
+	}
+
+	if single {
+		channels := export.Channels()
+		if !channels.Next() {
+			return cmp.Or(channels.Err(), fmt.Errorf("%w: %s", errNoChannels, input))
+		}
+
+		channel := channels.Channel()
+		if err := c.processChannel(channel, output, t); err != nil {
+			return fmt.Errorf("could not process file %q: %w", input, err)
+		}
+
+		c.copyChannelAssets(export.FS(), channel, outputDir)
+		c.copyEmoji(outputDir)
+		return nil
+	}
+
+	if err := c.processDirectory(export, output, t); err != nil {
+		return err
+	}
+
+	c.copyEmoji(outputDir)
+	return nil
+}
+
+// sameDir reports whether both paths are the same directory.
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+
+	return absA == absB
+}
+
+func (c *Converter) processDirectory(export *reader.Export, output string, t *template.Template) error {
+	it, err := template.New("index").Funcs(c.funcs()).Parse(index)
+	if err != nil {
+		return fmt.Errorf("could not parse index template: %w", err)
+	}
+
+	if err := os.MkdirAll(output, 0o755); err != nil {
+		return fmt.Errorf("could not create output directory: %w", err)
+	}
+
+	var allFiles []*structs.Data
+
+	channels := export.Channels()
+	for channels.Next() {
+		channel := channels.Channel()
+		// pages of all workspaces are next to each other, linked from one index
+		outputFilename := structs.TrimDataExt(path.Base(channel.Name)) + ".html"
+		c.channelDirs[channel.Data.Channel.ID] = channel.Dir()
+
+		slog.Info("Processing file", logging.KeyPath, channel.Name)
+		err := c.processChannel(channel, filepath.Join(output, outputFilename), t)
+		if err != nil {
+			if errors.Is(err, errChannelIsArchived) {
+				slog.Info("Channel is archived, skipping", logging.KeyPath, channel.Name)
+				continue
+			}
+
+			if errors.Is(err, errNoMessages) {
+				slog.Info("No messages found, skipping", logging.KeyPath, channel.Name)
+				continue
+			}
+
+			return fmt.Errorf("could not process file %q: %w", channel.Name, err)
+		}
+
+		c.copyChannelAssets(export.FS(), channel, output)
+		allFiles = append(allFiles, channel.Data)
+	}
+	if err := channels.Err(); err != nil {
+		return err
+	}
+
+	slog.Info("Generating index", logging.KeyPath, output)
+	return generateIndex(output, allFiles, it)
+}
+
+func (c *Converter) processChannel(channel *reader.Channel, output string, t *template.Template) error {
+	data := channel.Data
+
+	if data.Channel.IsArchived && c.opts.SkipArchived {
+		return errChannelIsArchived
+	}
+
+	if len(data.Messages) == 0 {
+		return errNoMessages
+	}
+
+	o, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("could not create file: %w", err)
+	}
+	defer o.Close()
+
+	slices.Reverse(data.Messages)
+
+	if err := t.Execute(o, data); err != nil {
+		return fmt.Errorf("could not execute template: %w", err)
+	}
+
+	return nil
+}
+
+// copyChannelAssets copies the downloaded files of the channel and the avatars
+// of its users from the export into output, if assets are copied.
+// Assets that can't be copied are left out with a warning.
+func (c *Converter) copyChannelAssets(fsys fs.FS, channel *reader.Channel, output string) {
+	if !c.copyAssets {
+		return
+	}
+
+	for fileID := range channel.Data.Files {
+		p := channel.FilePath(fileID)
+		err := copyAsset(p, output, func() (io.ReadCloser, error) {
+			return channel.OpenFile(fileID)
+		})
+		if err != nil {
+			slog.Warn("Could not copy attachment", logging.KeyFile, fileID, logging.KeyPath, p, logging.Err(err))
+		}
+	}
+
+	for id := range channel.Data.Users {
+		p := path.Join("avatars", id+".png")
+		err := copyAsset(p, output, func() (io.ReadCloser, error) {
+			return openAsset(fsys, p, c.opts.Secret)
+		})
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("Could not copy avatar", logging.KeyUser, id, logging.Err(err))
+		}
+	}
+}
+
+// copyEmoji copies the custom emoji into output, if assets are copied
+// and the emoji are not there already.
+func (c *Converter) copyEmoji(output string) {
+	if !c.copyAssets || c.slackEmoji == nil || sameDir(c.opts.EmojiDir, filepath.Join(output, "emoji")) {
+		return
+	}
+
+	for name := range c.slackEmoji {
+		_, filename := c.slackEmoji.Get(name)
+		if filename == "" {
+			continue
+		}
+
+		err := copyAsset(path.Join("emoji", filename), output, func() (io.ReadCloser, error) {
+			return os.Open(filepath.Join(c.opts.EmojiDir, filename))
+		})
+		if err != nil {
+			slog.Warn("Could not copy emoji", "emoji", name, logging.Err(err))
+		}
+	}
+}
+
+// copyAsset copies the asset at the slash-separated path p into output,
+// unless it was copied by an earlier conversion.
+func copyAsset(p, output string, open func() (io.ReadCloser, error)) error {
+	return structs.CopyAsset(filepath.Join(output, filepath.FromSlash(p)), open)
+}
+
+// openAsset opens the file in the export, decrypting it if needed.
+func openAsset(fsys fs.FS, p string, secret *structs.Secret) (io.ReadCloser, error) {
+	f, err := fsys.Open(p)
+	if err != nil {
+		return nil, err
+	}
+
+	// avatars are encrypted along with the channels
+	r, err := structs.OpenReader(f, secret)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, f}, nil
+}
+
+func generateIndex(output string, data []*structs.Data, t *template.Template) error {
+	o, err := os.Create(filepath.Join(output, "index.html"))
+	if err != nil {
+		return fmt.Errorf("could not create index file: %w", err)
+	}
+
+	// sort alphabetically
+	sort.Slice(data, func(i, j int) bool {
+		return title(data[i].Channel, data[i].Users) < title(data[j].Channel, data[j].Users)
+	})
+
+	if err := t.Execute(o, struct {
+		Data []*structs.Data
+	}{
+		Data: data,
+	}); err != nil {
+		return fmt.Errorf("could not execute index template: %w", err)
+	}
+
+	return nil
+}
+
+func lookupUser(id string, users map[string]*slack.User) *slack.User {
+	if id == "" {
+		return nil
+	}
+
+	if users == nil {
+		return nil
+	}
+
+	if user, ok := users[id]; ok {
+		return user
+	}
+
+	slog.Warn("User not found", logging.KeyUser, id)
+	return nil
+}
+
+var emojiSkinTone = regexp.MustCompile(`:skin-tone-(\d)`)
+
+func (c *Converter) emojiParse(s string) template.HTML {
+	if emojiSkinTone.MatchString(s) {
+		matches := emojiSkinTone.FindStringSubmatch(s)
+		tone := matches[1]
+		suffix := ""
+
+		switch tone {
+		case "2":
+			suffix = emoji.Light.String()
+		case "3":
+			suffix = emoji.MediumLight.String()
+		case "4":
+			suffix = emoji.Medium.String()
+		case "5":
+			suffix = emoji.MediumDark.String()
+		case "6":
+			suffix = emoji.Dark.String()
+		}
+
+		// remove skin tone suffix
+		s = strings.Split(s, "::skin-tone-")[0]
+		return template.HTML(emoji.Parse(":"+s+":") + suffix) // #nosec G203
+	}
+
+	alias, filename := c.slackEmoji.Get(s)
+	if alias != "" {
+		return template.HTML(emoji.Parse(":" + alias + ":")) // #nosec G203
+	}
+
+	if filename != "" {
+		return template.HTML( // #nosec G203
+			fmt.Sprintf("<img class=\"emoji\" src=\"emoji/%s\" alt=\":%s:\" />", filename, s),
+		)
+	}
+
+	return template.HTML(emoji.Parse(":" + s + ":")) // #nosec G203
+}
+
+func (c *Converter) processRichTextElements(
+	elements []slack.RichTextElement,
+	users map[string]*slack.User,
+	transforms ...func(string) string,
+) string {
+	result := strings.Builder{}
+
+	for _, element := range elements {
+		sb := strings.Builder{}
+		switch element.RichTextElementType() {
+		case slack.RTESection:
+			sb.WriteString(
+				c.processRichTextSectionElements(element.(*slack.RichTextSection).Elements, users),
+			)
+		case slack.RTEQuote:
+			sb.WriteString(
+				fmt.Sprintf(
+					"<blockquote>%s</blockquote>",
+					c.processRichTextSectionElements(element.(*slack.RichTextQuote).Elements, users),
+				),
+			)
+		case slack.RTEPreformatted:
+			sb.WriteString("<pre>")
+			for _, rtEelement := range element.(*slack.RichTextPreformatted).Elements {
+				switch rtEelement.RichTextSectionElementType() {
+				case slack.RTSEText:
+					te, ok := rtEelement.(*slack.RichTextSectionTextElement)
+					if !ok {
+						slog.Warn("Could not cast to RichTextSectionTextElement")
+						continue
+					}
+					text := html.EscapeString(te.Text)
+					sb.WriteString(text)
+				case slack.RTSELink:
+					if rtEelement.(*slack.RichTextSectionLinkElement).Text != "" {
+						sb.WriteString(fmt.Sprintf("<a href=%q>%s</a>", rtEelement.(*slack.RichTextSectionLinkElement).URL, rtEelement.(*slack.RichTextSectionLinkElement).Text))
+					} else {
+						sb.WriteString(fmt.Sprintf("<a href=%q>%s</a>", rtEelement.(*slack.RichTextSectionLinkElement).URL, rtEelement.(*slack.RichTextSectionLinkElement).URL))
+					}
+				}
+			}
+			sb.WriteString("</pre>")
+		case slack.RTEList:
+			var tag string
+			switch element.(*slack.RichTextList).Style {
+			case slack.RTEListBullet:
+				tag = "ul"
+			case slack.RTEListOrdered:
+				tag = "ol"
+			}
+
+			sb.WriteString(fmt.Sprintf("<%s>", tag))
+
+			sb.WriteString(
+				c.processRichTextElements(
+					element.(*slack.RichTextList).Elements,
+					users,
+					func(s string) string {
+						return fmt.Sprintf("<li>%s</li>", s)
+					},
+				),
+			)
+
+			sb.WriteString(fmt.Sprintf("</%s>", tag))
+		}
+
+		if len(transforms) > 0 {
+			for _, transform := range transforms {
+				result.WriteString(transform(sb.String()))
+			}
+		} else {
+			result.WriteString(sb.String())
+		}
+	}
+
+	return result.String()
+}
+
+func (c *Converter) processRichTextSectionElements(elements []slack.RichTextSectionElement, users map[string]*slack.User) string {
+	sb := strings.Builder{}
+	var code bool
+
+	for _, rtEelement := range elements {
+		switch rtEelement.RichTextSectionElementType() {
+		case slack.RTSEText:
+			te, ok := rtEelement.(*slack.RichTextSectionTextElement)
+			if !ok {
+				slog.Warn("Could not cast to RichTextSectionTextElement")
+				continue
+			}
+			text := html.EscapeString(te.Text)
+			text = strings.ReplaceAll(text, "\n", "<br>")
+
+			if code && (te.Style == nil || !te.Style.Code) {
+				code = false
+				sb.WriteString("</code>")
+			}
+
+			if te.Style != nil {
+				if te.Style.Bold {
+					text = fmt.Sprintf("<b>%s</b>", text)
+				}
+				if te.Style.Italic {
+					text = fmt.Sprintf("<i>%s</i>", text)
+				}
+				if te.Style.Strike {
+					text = fmt.Sprintf("<s>%s</s>", text)
+				}
+				if te.Style.Code {
+					if !code {
+						code = true
+						text = fmt.Sprintf("<code>%s", text)
+					}
+				}
+			}
+
+			sb.WriteString(text)
+		case slack.RTSEUser:
+			sb.WriteString(
+				"<span class=\"user\">" +
+					structs.UserName(lookupUser(rtEelement.(*slack.RichTextSectionUserElement).UserID, users)) +
+					"</span>",
+			)
+		case slack.RTSEEmoji:
+			sb.WriteString(
+				string(c.emojiParse(rtEelement.(*slack.RichTextSectionEmojiElement).Name)),
+			)
+		case slack.RTSELink:
+			if rtEelement.(*slack.RichTextSectionLinkElement).Text != "" {
+				sb.WriteString(fmt.Sprintf("<a href=%q>%s</a>", rtEelement.(*slack.RichTextSectionLinkElement).URL, rtEelement.(*slack.RichTextSectionLinkElement).Text))
+			} else {
+				sb.WriteString(fmt.Sprintf("<a href=%q>%s</a>", rtEelement.(*slack.RichTextSectionLinkElement).URL, rtEelement.(*slack.RichTextSectionLinkElement).URL))
+			}
+		}
+	}
+
+	if code {
+		sb.WriteString("</code>")
+	}
+
+	return sb.String()
+}
+
+func maxLength(w, h, maxW, maxH int) (width, height int) {
+	if w > maxW {
+		h = h * maxW / w
+		w = maxW
+	}
+
+	if h > maxH {
+		w = w * maxH / h
+		h = maxH
+	}
+
+	return w, h
+}
+
+func title(channel slack.Channel, users map[string]*slack.User) string {
+	switch {
+	case channel.IsIM:
+		return "👤 " + structs.UserName(lookupUser(channel.User, users))
+	case channel.IsGroup, channel.IsMpIM:
+		return strings.Replace(
+			channel.Purpose.Value,
+			"Group messaging with: ",
+			"👥 ",
+			1,
+		)
+	default:
+		if channel.IsPrivate {
+			return "🔒 " + channel.Name
+		}
+		return "# " + channel.Name
+	}
+}
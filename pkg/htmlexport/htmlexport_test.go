@@ -0,0 +1,90 @@
+package htmlexport
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+const channel = `{
+  "schema_version": 4,
+  "channel": {"id": "C1", "name": "general"},
+  "users": {"U1": {"id": "U1", "name": "ann"}},
+  "files": {"F1": "report.txt"},
+  "messages": [
+    {"type": "message", "user": "U1", "text": "report", "ts": "1700000200.000100",
+     "files": [{"id": "F1", "name": "report.txt", "title": "report.txt", "filetype": "text"}]}
+  ]
+}`
+
+// writeExport writes an encrypted export of one workspace of an
+// Enterprise Grid organization, with an attachment and an avatar.
+func writeExport(t *testing.T, secret *structs.Secret) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for _, d := range []string{"T1/C1", "avatars"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index := `{"workspaces": [{"id": "T1", "name": "Engineering", "channels": ["C1"]}]}`
+	if err := os.WriteFile(filepath.Join(dir, structs.WorkspacesName), []byte(index), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"T1/C1/F1-report.txt": "quarterly numbers",
+		"avatars/U1.png":      "png",
+	}
+	for name, content := range files {
+		if err := structs.WriteFile(filepath.Join(dir, name), []byte(content), secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := structs.WriteData(filepath.Join(dir, "T1", "C1.json"), []byte(channel), secret); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestConvertCopiesAssets(t *testing.T) {
+	secret := structs.NewPassphrase("secret")
+	input := writeExport(t, secret)
+	output := filepath.Join(t.TempDir(), "html")
+
+	if err := New(Options{Secret: secret}).Convert(input, output); err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+
+	page, err := os.ReadFile(filepath.Join(output, "C1.html"))
+	if err != nil {
+		t.Fatalf("could not read page: %v", err)
+	}
+	for _, link := range []string{`href="T1/C1/F1-report.txt"`, `src="avatars/U1.png"`} {
+		if !strings.Contains(string(page), link) {
+			t.Errorf("page does not link %s", link)
+		}
+	}
+
+	// the links resolve to the decrypted files
+	for name, want := range map[string]string{
+		"T1/C1/F1-report.txt": "quarterly numbers",
+		"avatars/U1.png":      "png",
+	} {
+		got, err := os.ReadFile(filepath.Join(output, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("could not read %s: %v", name, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
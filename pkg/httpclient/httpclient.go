@@ -0,0 +1,76 @@
+// Package httpclient builds the HTTP client shared by the Slack API client
+// and the downloads of files, avatars and emoji, so that proxy, CA and
+// timeout settings apply to every request the tools send.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+var errNoCertificates = errors.New("no certificates found")
+
+// Options are the command line options for HTTP,
+// meant to be embedded into the tools' config structs.
+type Options struct {
+	Proxy          string        `env:"HTTP_PROXY_URL" long:"proxy" description:"HTTP proxy URL, like http://proxy:3128; HTTPS_PROXY and NO_PROXY are used if empty"`
+	CABundle       string        `env:"CA_BUNDLE" long:"ca-bundle" description:"PEM file with CA certificates to trust in addition to the system ones"`
+	Timeout        time.Duration `env:"HTTP_TIMEOUT" long:"http-timeout" description:"Time limit for a whole request, including reading the response; 0 for none" default:"10m"`
+	ConnectTimeout time.Duration `env:"HTTP_CONNECT_TIMEOUT" long:"http-connect-timeout" description:"Time limit for connecting, including the TLS handshake" default:"30s"`
+}
+
+// New returns a client with the options applied.
+func New(opts Options) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if opts.Proxy != "" {
+		proxy, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if opts.CABundle != "" {
+		pool, err := loadCABundle(opts.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	if opts.ConnectTimeout > 0 {
+		dialer := &net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = opts.ConnectTimeout
+	}
+
+	return &http.Client{Transport: transport, Timeout: opts.Timeout}, nil
+}
+
+// loadCABundle returns the system certificates with the ones from the file added.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA bundle: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		// not available on every platform, trust the bundle only
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%w in CA bundle %s", errNoCertificates, path)
+	}
+
+	return pool, nil
+}
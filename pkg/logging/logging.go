@@ -0,0 +1,61 @@
+// Package logging configures log/slog for the exporter and its tools,
+// and defines the attribute keys shared by all of them.
+package logging
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Attribute keys used across the exporter logs,
+// so that log consumers can filter on them.
+const (
+	KeyChannel = "channel_id"
+	KeyThread  = "thread_ts"
+	KeyFile    = "file_id"
+	KeyUser    = "user_id"
+	KeyMethod  = "method"
+	KeyPath    = "path"
+	KeyError   = "error"
+)
+
+var errUnknownFormat = fmt.Errorf("unknown log format")
+
+// Options are the command line options for logging,
+// meant to be embedded into the tools' config structs.
+type Options struct {
+	Level  string `env:"LOG_LEVEL" long:"log-level" description:"Log level" choice:"debug" choice:"info" choice:"warn" choice:"error" default:"info"`
+	Format string `env:"LOG_FORMAT" long:"log-format" description:"Log format" choice:"text" choice:"json" default:"text"`
+}
+
+// Setup replaces the default slog logger with one writing to w.
+// The standard log package is redirected to it as well.
+func Setup(w io.Writer, opts Options) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cmp.Or(opts.Level, "info"))); err != nil {
+		return fmt.Errorf("could not parse log level: %w", err)
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch strings.ToLower(cmp.Or(opts.Format, "text")) {
+	case "text":
+		handler = slog.NewTextHandler(w, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	default:
+		return fmt.Errorf("%w: %q", errUnknownFormat, opts.Format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// Err returns the error attribute.
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
@@ -0,0 +1,218 @@
+package reader
+
+import (
+	"cmp"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// Channel is an exported channel with its messages, users and files.
+type Channel struct {
+	Data *structs.Data
+
+	// Name is the path of the channel file in the export FS,
+	// like C0000000000.json or T0000000000/C0000000000.json.
+	Name string
+
+	export *Export
+}
+
+// Title returns the channel name,
+// or the name of the other user for direct messages.
+func (c *Channel) Title() string {
+	return structs.ChannelName(c.Data.Channel, c.Data.Users)
+}
+
+// User returns the user with the given ID, or nil if it wasn't exported.
+func (c *Channel) User(id string) *slack.User {
+	return c.Data.Users[id]
+}
+
+// Resolver returns the resolver for mentions in messages of the channel:
+// users of the channel and channels of the export read so far.
+func (c *Channel) Resolver() structs.Resolver {
+	return structs.Resolver{Users: c.Data.Users, Channels: c.export.channelNames}
+}
+
+// FilePath returns the path of the downloaded file in the export FS,
+// or an empty string if the file was not downloaded.
+func (c *Channel) FilePath(fileID string) string {
+	filename, ok := c.Data.Files[fileID]
+	if !ok {
+		return ""
+	}
+
+	return path.Join(c.Dir(), c.Data.Channel.ID, fileID+"-"+filename)
+}
+
+// Dir returns the directory of the channel in the export FS:
+// "." or the directory of its Enterprise Grid workspace.
+func (c *Channel) Dir() string {
+	return path.Dir(c.Name)
+}
+
+// OpenFile opens the downloaded file, decrypting it if needed.
+// It returns fs.ErrNotExist if the file was not downloaded.
+func (c *Channel) OpenFile(fileID string) (io.ReadCloser, error) {
+	p := c.FilePath(fileID)
+	if p == "" {
+		return nil, &fs.PathError{Op: "open", Path: fileID, Err: fs.ErrNotExist}
+	}
+
+	f, err := c.export.fsys.Open(p)
+	if err != nil {
+		return nil, err
+	}
+
+	// attachments are encrypted along with the channels
+	r, err := structs.OpenReader(f, c.export.secret)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, f}, nil
+}
+
+// Messages returns an iterator over the top-level messages, oldest first.
+// Thread replies are available from Message.Thread.
+func (c *Channel) Messages() *Messages {
+	return &Messages{channel: c, messages: sortedMessages(c.Data.Messages)}
+}
+
+// Walk calls fn for every message of the channel, oldest first,
+// with every thread parent followed by its replies.
+// It stops at the first error fn returns.
+func (c *Channel) Walk(fn func(m *Message) error) error {
+	messages := c.Messages()
+	for messages.Next() {
+		m := messages.Message()
+		if err := fn(m); err != nil {
+			return err
+		}
+
+		for _, reply := range m.Thread() {
+			if err := fn(reply); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Messages iterates over the top-level messages of a Channel.
+type Messages struct {
+	channel  *Channel
+	messages []structs.Message
+	next     int
+	message  *Message
+}
+
+// Next moves to the next message and reports whether there is one.
+func (it *Messages) Next() bool {
+	if it.next >= len(it.messages) {
+		it.message = nil
+		return false
+	}
+
+	m := it.messages[it.next]
+	it.next++
+	it.message = &Message{Message: m.Message, channel: it.channel, replies: m.Replies}
+
+	return true
+}
+
+// Message returns the current message.
+func (it *Messages) Message() *Message {
+	return it.message
+}
+
+// Message is a message of an exported channel.
+type Message struct {
+	slack.Message
+
+	channel *Channel
+	parent  *Message
+	replies []slack.Message
+}
+
+// Parent returns the thread parent of a reply, or nil for other messages.
+func (m *Message) Parent() *Message {
+	return m.parent
+}
+
+// Thread returns the exported replies of a thread parent, oldest first.
+func (m *Message) Thread() []*Message {
+	replies := make([]*Message, 0, len(m.replies))
+	for _, reply := range m.replies {
+		// conversations.replies includes the parent
+		if reply.Timestamp == m.Timestamp {
+			continue
+		}
+
+		replies = append(replies, &Message{Message: reply, channel: m.channel, parent: m})
+	}
+
+	return replies
+}
+
+// Time returns the time the message was posted, in UTC,
+// or the zero time if its timestamp is malformed.
+func (m *Message) Time() time.Time {
+	t, _ := structs.ParseTimestamp(m.Timestamp)
+	return t
+}
+
+// Sender returns the author, or nil for bots and users that weren't exported.
+func (m *Message) Sender() *slack.User {
+	return m.channel.User(m.User)
+}
+
+// Author returns the name of the author: the user name,
+// or the bot name for messages without a user.
+func (m *Message) Author() string {
+	if m.User == "" {
+		return cmp.Or(m.Username, m.BotID, "unknown")
+	}
+
+	if user := m.Sender(); user != nil {
+		return structs.UserName(user)
+	}
+
+	return m.User
+}
+
+// PlainText returns the text of the message without formatting,
+// with user and channel mentions resolved to names.
+func (m *Message) PlainText() string {
+	return m.channel.Resolver().PlainText(m.Message)
+}
+
+// File is a file shared in a message.
+type File struct {
+	slack.File
+
+	// Path is the path of the downloaded file in the export FS,
+	// or empty if it was not downloaded.
+	Path string
+}
+
+// Attachments returns the files shared in the message.
+func (m *Message) Attachments() []File {
+	files := make([]File, 0, len(m.Files))
+	for _, f := range m.Files {
+		files = append(files, File{File: f, Path: m.channel.FilePath(f.ID)})
+	}
+
+	return files
+}
@@ -0,0 +1,260 @@
+// Package reader reads exports written by slack-exporter, for tools
+// that analyze or convert them without depending on package main.
+//
+// An export is opened from a single channel file, an export directory
+// or a bundle (.zip or .tar.gz); files may be gzip-compressed or encrypted.
+// Enterprise Grid exports are read with the channels of all workspaces.
+// Channels are read one file at a time while iterating, and older documents
+// are upgraded to the current schema as they are read:
+//
+//	export, err := reader.Open("output.zip", reader.WithSecret(secret))
+//	if err != nil {
+//		return err
+//	}
+//	defer export.Close()
+//
+//	channels := export.Channels()
+//	for channels.Next() {
+//		channel := channels.Channel()
+//		err := channel.Walk(func(m *reader.Message) error {
+//			fmt.Println(m.Time(), m.Author(), m.PlainText())
+//			return nil
+//		})
+//		if err != nil {
+//			return err
+//		}
+//	}
+//	return channels.Err()
+//
+// An Export is not safe for concurrent use.
+package reader
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// Export is an opened export.
+type Export struct {
+	fsys   fs.FS
+	closer io.Closer
+	names  []string
+	secret *structs.Secret
+
+	// workspaces is the index of an Enterprise Grid export, if it is one
+	workspaces *structs.Workspaces
+
+	// channelNames are the names of the channels read so far, by ID,
+	// used to resolve channel mentions
+	channelNames map[string]string
+	read         map[string]bool
+}
+
+// Option configures an Export.
+type Option func(*Export)
+
+// WithSecret sets the passphrase or key used to decrypt encrypted files.
+func WithSecret(secret *structs.Secret) Option {
+	return func(e *Export) {
+		e.secret = secret
+	}
+}
+
+// Open opens a single exported channel, an export directory or a bundle.
+// The export must be closed when done.
+func Open(path string, options ...Option) (*Export, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not get file info: %w", err)
+	}
+
+	e := &Export{
+		channelNames: map[string]string{},
+		read:         map[string]bool{},
+	}
+	for _, option := range options {
+		option(e)
+	}
+
+	if !info.IsDir() && !structs.IsBundle(path) {
+		// attachments are stored next to the file, like in a directory
+		dir, name := filepath.Split(path)
+		e.fsys = os.DirFS(filepath.Clean(dir))
+		e.closer = io.NopCloser(nil)
+		e.names = []string{name}
+		return e, nil
+	}
+
+	e.fsys, e.closer, err = structs.OpenDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := fs.ReadDir(e.fsys, ".")
+	if err != nil {
+		e.closer.Close()
+		return nil, fmt.Errorf("could not read directory: %w", err)
+	}
+
+	for _, file := range files {
+		if !file.IsDir() && isChannelFile(file.Name()) {
+			e.names = append(e.names, file.Name())
+		}
+	}
+
+	// channels of Enterprise Grid workspaces are in subdirectories
+	e.workspaces, err = structs.ReadWorkspaces(e.fsys)
+	if err != nil {
+		e.closer.Close()
+		return nil, err
+	}
+
+	if e.workspaces != nil {
+		for _, w := range e.workspaces.Workspaces {
+			files, err := fs.ReadDir(e.fsys, w.ID)
+			if err != nil {
+				e.closer.Close()
+				return nil, fmt.Errorf("could not read workspace directory: %w", err)
+			}
+
+			for _, file := range files {
+				if !file.IsDir() && isChannelFile(file.Name()) {
+					e.names = append(e.names, w.ID+"/"+file.Name())
+				}
+			}
+		}
+	}
+
+	return e, nil
+}
+
+// isChannelFile reports whether the file may be an exported channel.
+func isChannelFile(name string) bool {
+	return structs.IsDataFile(name) && name != structs.ManifestName && name != structs.WorkspacesName
+}
+
+// Close closes the bundle, if the export is one.
+func (e *Export) Close() error {
+	return e.closer.Close()
+}
+
+// FS returns the root of the export, with attachments and avatars
+// in subdirectories. For a single file it is the directory of the file.
+func (e *Export) FS() fs.FS {
+	return e.fsys
+}
+
+// Workspaces returns the workspace index of an Enterprise Grid export,
+// or nil if the export is not one.
+func (e *Export) Workspaces() *structs.Workspaces {
+	return e.workspaces
+}
+
+// Names returns the file names of the exported channels, sorted,
+// with the channels of Enterprise Grid workspaces after the others,
+// prefixed with the workspace directory.
+// Other JSON files, like the error report, are listed too;
+// Channels skips them.
+func (e *Export) Names() []string {
+	return e.names
+}
+
+// Channel reads the exported channel from the file with the given name.
+func (e *Export) Channel(name string) (*Channel, error) {
+	data, err := structs.ReadFileFS(e.fsys, name, e.secret)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file %q: %w", name, err)
+	}
+
+	e.read[name] = true
+	if data.Channel.ID != "" {
+		e.channelNames[data.Channel.ID] = structs.ChannelName(data.Channel, data.Users)
+	}
+
+	return &Channel{Data: data, Name: name, export: e}, nil
+}
+
+// Channels returns an iterator over the exported channels,
+// reading one file per call to Next.
+func (e *Export) Channels() *Channels {
+	return &Channels{export: e}
+}
+
+// ChannelNames returns the names of all exported channels by ID,
+// reading the files that were not read yet.
+//
+// Channel mentions are only resolved for channels that were read,
+// so call it before iterating to resolve mentions of channels
+// that come later in the export.
+func (e *Export) ChannelNames() (map[string]string, error) {
+	for _, name := range e.names {
+		if e.read[name] {
+			continue
+		}
+
+		if _, err := e.Channel(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return e.channelNames, nil
+}
+
+// Channels iterates over the exported channels of an Export.
+// Files that are not exported channels, like the error report, are skipped.
+type Channels struct {
+	export  *Export
+	next    int
+	channel *Channel
+	err     error
+}
+
+// Next reads the next channel. It returns false when there are no more
+// channels or reading failed; check Err to tell them apart.
+func (it *Channels) Next() bool {
+	for it.err == nil && it.next < len(it.export.names) {
+		name := it.export.names[it.next]
+		it.next++
+
+		it.channel, it.err = it.export.Channel(name)
+		if it.err != nil {
+			it.channel = nil
+			return false
+		}
+
+		if it.channel.Data.Channel.ID != "" {
+			return true
+		}
+	}
+
+	it.channel = nil
+	return false
+}
+
+// Channel returns the channel read by the last call to Next.
+func (it *Channels) Channel() *Channel {
+	return it.channel
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Channels) Err() error {
+	return it.err
+}
+
+// sortedMessages returns the messages oldest first;
+// the exporter writes them newest first.
+func sortedMessages(messages []structs.Message) []structs.Message {
+	sorted := make([]structs.Message, len(messages))
+	copy(sorted, messages)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp < sorted[j].Timestamp
+	})
+
+	return sorted
+}
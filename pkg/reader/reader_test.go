@@ -0,0 +1,359 @@
+package reader_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/chuhlomin/slack-exporter/pkg/reader"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// general has a thread, a mention of each kind and a downloaded file;
+// messages are newest first and replies include the parent, like the exporter
+// writes them.
+const general = `{
+  "schema_version": 4,
+  "channel": {"id": "C1", "name": "general"},
+  "users": {"U1": {"id": "U1", "name": "ann", "real_name": "Ann Lee"}},
+  "files": {"F1": "report.txt"},
+  "messages": [
+    {"type": "message", "user": "U1", "text": "ping <@U1> in <#C2>", "ts": "1700000300.000100"},
+    {"type": "message", "user": "U1", "text": "report", "ts": "1700000200.000100",
+     "files": [{"id": "F1", "name": "report.txt"}]},
+    {"type": "message", "user": "U1", "text": "question", "ts": "1700000100.000100",
+     "thread_ts": "1700000100.000100", "reply_count": 2,
+     "replies": [
+       {"type": "message", "user": "U1", "text": "question", "ts": "1700000100.000100", "thread_ts": "1700000100.000100"},
+       {"type": "message", "user": "U1", "text": "first answer", "ts": "1700000150.000100", "thread_ts": "1700000100.000100"},
+       {"type": "message", "bot_id": "B1", "username": "helper", "text": "second answer", "ts": "1700000160.000100", "thread_ts": "1700000100.000100"}
+     ]}
+  ]
+}`
+
+// random was written before schema versions.
+const random = `{
+  "channel": {"id": "C2", "name": "random"},
+  "users": {},
+  "files": {},
+  "messages": [{"type": "message", "text": "hello", "ts": "1700000400.000100"}]
+}`
+
+const attachment = "quarterly numbers"
+
+// writeExport writes the channels, with the attachment of general,
+// to dir, compressing and encrypting them as requested.
+func writeExport(t *testing.T, dir, ext string, secret *structs.Secret) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Join(dir, "C1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{"C1": general, "C2": random} {
+		if err := structs.WriteData(filepath.Join(dir, name+ext), []byte(content), secret); err != nil {
+			t.Fatalf("could not write %s: %v", name, err)
+		}
+	}
+
+	if err := structs.WriteFile(filepath.Join(dir, "C1", "F1-report.txt"), []byte(attachment), secret); err != nil {
+		t.Fatalf("could not write attachment: %v", err)
+	}
+
+	// written by the exporter, not channels
+	for _, name := range []string{structs.ManifestName, "errors.json"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(`{}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func writeBundle(t *testing.T, format string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	writeExport(t, dir, ".json", nil)
+
+	bundle := filepath.Join(t.TempDir(), "output."+format)
+	if err := structs.WriteBundle(dir, bundle); err != nil {
+		t.Fatalf("could not write bundle: %v", err)
+	}
+
+	return bundle
+}
+
+// readAll returns the IDs of the channels of the export, sorted,
+// and checks the attachment of general.
+func readAll(t *testing.T, path string, options ...reader.Option) []string {
+	t.Helper()
+
+	export, err := reader.Open(path, options...)
+	if err != nil {
+		t.Fatalf("could not open %s: %v", path, err)
+	}
+	defer export.Close()
+
+	var ids []string
+	channels := export.Channels()
+	for channels.Next() {
+		channel := channels.Channel()
+		ids = append(ids, channel.Data.Channel.ID)
+
+		if channel.Data.SchemaVersion != structs.SchemaVersion {
+			t.Errorf("%s: schema version %d, want %d", channel.Name, channel.Data.SchemaVersion, structs.SchemaVersion)
+		}
+
+		if channel.Data.Channel.ID == "C1" {
+			checkAttachment(t, channel)
+		}
+	}
+	if err := channels.Err(); err != nil {
+		t.Fatalf("could not read channels of %s: %v", path, err)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+func checkAttachment(t *testing.T, channel *reader.Channel) {
+	t.Helper()
+
+	f, err := channel.OpenFile("F1")
+	if err != nil {
+		t.Fatalf("could not open attachment: %v", err)
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("could not read attachment: %v", err)
+	}
+	if string(content) != attachment {
+		t.Errorf("attachment = %q, want %q", content, attachment)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	writeExport(t, dir, ".json", nil)
+
+	compressed := t.TempDir()
+	writeExport(t, compressed, ".json.gz", nil)
+
+	tests := []struct {
+		name string
+		path string
+		want []string
+	}{
+		{"directory", dir, []string{"C1", "C2"}},
+		{"compressed directory", compressed, []string{"C1", "C2"}},
+		{"single file", filepath.Join(dir, "C1.json"), []string{"C1"}},
+		{"single compressed file", filepath.Join(compressed, "C1.json.gz"), []string{"C1"}},
+		{"zip bundle", writeBundle(t, structs.BundleZip), []string{"C1", "C2"}},
+		{"tar.gz bundle", writeBundle(t, structs.BundleTarGz), []string{"C1", "C2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readAll(t, tt.path); !slices.Equal(got, tt.want) {
+				t.Errorf("channels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	writeExport(t, dir, ".json.gz", structs.NewPassphrase("secret"))
+
+	got := readAll(t, dir, reader.WithSecret(structs.NewPassphrase("secret")))
+	if want := []string{"C1", "C2"}; !slices.Equal(got, want) {
+		t.Errorf("channels = %v, want %v", got, want)
+	}
+
+	export, err := reader.Open(dir)
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	defer export.Close()
+
+	if channels := export.Channels(); channels.Next() || channels.Err() == nil {
+		t.Error("read an encrypted channel without the secret")
+	}
+}
+
+func TestOpenGrid(t *testing.T) {
+	dir := t.TempDir()
+	writeExport(t, filepath.Join(dir, "T1"), ".json", nil)
+
+	// the second workspace has a channel of its own
+	if err := os.MkdirAll(filepath.Join(dir, "T2"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "T1", "C2.json"), filepath.Join(dir, "T2", "C2.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	index := `{"workspaces": [
+	  {"id": "T1", "name": "Engineering", "channels": ["C1"]},
+	  {"id": "T2", "name": "Sales", "channels": ["C2"]}
+	]}`
+	if err := os.WriteFile(filepath.Join(dir, structs.WorkspacesName), []byte(index), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "errors.json"), []byte(`{}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := reader.Open(dir)
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	defer export.Close()
+
+	if w := export.Workspaces(); w == nil || len(w.Workspaces) != 2 {
+		t.Fatalf("workspaces = %+v, want T1 and T2", w)
+	}
+
+	var names []string
+	channels := export.Channels()
+	for channels.Next() {
+		channel := channels.Channel()
+		names = append(names, channel.Name)
+
+		if channel.Data.Channel.ID == "C1" {
+			if channel.Dir() != "T1" {
+				t.Errorf("Dir() = %q, want T1", channel.Dir())
+			}
+			if p := channel.FilePath("F1"); p != "T1/C1/F1-report.txt" {
+				t.Errorf("FilePath(F1) = %q, want T1/C1/F1-report.txt", p)
+			}
+			checkAttachment(t, channel)
+		}
+	}
+	if err := channels.Err(); err != nil {
+		t.Fatalf("could not read channels: %v", err)
+	}
+
+	if want := []string{"T1/C1.json", "T2/C2.json"}; !slices.Equal(names, want) {
+		t.Errorf("channels = %v, want %v", names, want)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	channel := openGeneral(t)
+
+	var got []string
+	err := channel.Walk(func(m *reader.Message) error {
+		entry := m.Timestamp + " " + m.Author()
+		if parent := m.Parent(); parent != nil {
+			entry += " in " + parent.Timestamp
+		}
+		got = append(got, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+
+	// oldest first, every parent followed by its replies, without the parent again
+	want := []string{
+		"1700000100.000100 Ann Lee",
+		"1700000150.000100 Ann Lee in 1700000100.000100",
+		"1700000160.000100 helper in 1700000100.000100",
+		"1700000200.000100 Ann Lee",
+		"1700000300.000100 Ann Lee",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Walk order:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	dir := t.TempDir()
+	writeExport(t, dir, ".json", nil)
+
+	export, err := reader.Open(dir)
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	defer export.Close()
+
+	channel, err := export.Channel("C1.json")
+	if err != nil {
+		t.Fatalf("could not read channel: %v", err)
+	}
+
+	mention := newest(t, channel)
+
+	// random was not read yet
+	if got, want := mention.PlainText(), "ping @Ann Lee in #C2"; got != want {
+		t.Errorf("PlainText() = %q, want %q", got, want)
+	}
+
+	if _, err := export.ChannelNames(); err != nil {
+		t.Fatalf("ChannelNames: %v", err)
+	}
+
+	if got, want := mention.PlainText(), "ping @Ann Lee in #random"; got != want {
+		t.Errorf("PlainText() after ChannelNames = %q, want %q", got, want)
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	channel := openGeneral(t)
+
+	messages := channel.Messages()
+	for messages.Next() {
+		m := messages.Message()
+		if m.Timestamp != "1700000200.000100" {
+			continue
+		}
+
+		files := m.Attachments()
+		if len(files) != 1 || files[0].Path != "C1/F1-report.txt" {
+			t.Errorf("Attachments() = %+v, want F1 at C1/F1-report.txt", files)
+		}
+		return
+	}
+
+	t.Error("message with the attachment not found")
+}
+
+func openGeneral(t *testing.T) *reader.Channel {
+	t.Helper()
+
+	dir := t.TempDir()
+	writeExport(t, dir, ".json", nil)
+
+	export, err := reader.Open(filepath.Join(dir, "C1.json"))
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	t.Cleanup(func() { export.Close() })
+
+	channels := export.Channels()
+	if !channels.Next() {
+		t.Fatalf("could not read channel: %v", channels.Err())
+	}
+
+	return channels.Channel()
+}
+
+func newest(t *testing.T, channel *reader.Channel) *reader.Message {
+	t.Helper()
+
+	var last *reader.Message
+	messages := channel.Messages()
+	for messages.Next() {
+		last = messages.Message()
+	}
+	if last == nil {
+		t.Fatal("channel has no messages")
+	}
+
+	return last
+}
@@ -0,0 +1,322 @@
+// Package recording records HTTP traffic to a directory and replays it,
+// so that an export can be reproduced without access to the workspace.
+//
+// Every request is stored as two files: <n>.json with the request and
+// the response status and headers, and <n>.body with the response body.
+// Tokens are scrubbed from URLs, form values and bodies, and email addresses
+// in text bodies are replaced with numbered placeholders, so recordings
+// can be shared and kept as test fixtures.
+//
+// Requests are matched by method, URL and form values without the token.
+// Identical requests are replayed in the order they were recorded.
+package recording
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// scrubbedToken replaces Slack tokens in recordings.
+const scrubbedToken = "xoxp-scrubbed"
+
+var (
+	reToken = regexp.MustCompile(`xox[a-z]-[A-Za-z0-9-]+`)
+	reEmail = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+	// headers kept in recordings, the others may carry cookies or request IDs
+	keptHeaders = []string{"Content-Type", "Content-Disposition", "Retry-After"}
+
+	errRecordingExists = errors.New("directory already contains a recording")
+	errNotRecorded     = errors.New("request was not recorded")
+)
+
+// interaction is a recorded request and its response, without the body.
+type interaction struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Form   url.Values  `json:"form,omitempty"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+}
+
+// key identifies the request for replay.
+func (i *interaction) key() string {
+	return i.Method + " " + i.URL + " " + i.Form.Encode()
+}
+
+// Recorder is an http.RoundTripper that sends requests
+// through another RoundTripper and records them.
+type Recorder struct {
+	dir  string
+	next http.RoundTripper
+
+	mu     sync.Mutex
+	n      int
+	emails map[string]string // address -> placeholder
+}
+
+// NewRecorder creates a Recorder writing to dir, which must not
+// contain a recording yet. If next is nil, http.DefaultTransport is used.
+func NewRecorder(dir string, next http.RoundTripper) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create recording directory: %w", err)
+	}
+
+	existing, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return nil, fmt.Errorf("%w: %s", errRecordingExists, dir)
+	}
+
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &Recorder{dir: dir, next: next, emails: map[string]string{}}, nil
+}
+
+// RoundTrip sends the request and records the response.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	i, err := newInteraction(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read response: %w", err)
+	}
+
+	i.Status = resp.StatusCode
+	i.Header = http.Header{}
+	for _, name := range keptHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			i.Header.Set(name, value)
+		}
+	}
+
+	if err := r.save(i, r.scrubBody(body, resp.Header.Get("Content-Type"))); err != nil {
+		return nil, err
+	}
+
+	// the caller gets the original, unscrubbed response
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+func (r *Recorder) save(i *interaction, body []byte) error {
+	r.mu.Lock()
+	r.n++
+	name := filepath.Join(r.dir, fmt.Sprintf("%04d", r.n))
+	r.mu.Unlock()
+
+	content, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal interaction: %w", err)
+	}
+
+	if err := os.WriteFile(name+".body", body, 0o600); err != nil {
+		return fmt.Errorf("could not write recording: %w", err)
+	}
+
+	// the metadata is written last, so that it always has a body
+	if err := os.WriteFile(name+".json", content, 0o600); err != nil {
+		return fmt.Errorf("could not write recording: %w", err)
+	}
+
+	return nil
+}
+
+// Replayer is an http.RoundTripper that serves recorded responses
+// and never touches the network.
+type Replayer struct {
+	mu      sync.Mutex
+	queues  map[string][]string // key -> recording names, in order
+	lastHit map[string]string   // key -> last replayed name
+	dir     string
+}
+
+// NewReplayer loads the recording from dir.
+func NewReplayer(dir string) (*Replayer, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no recording found in %s", dir)
+	}
+
+	// recordings are numbered, replay them in order
+	sort.Slice(names, func(a, b int) bool {
+		na, _ := strconv.Atoi(strings.TrimSuffix(filepath.Base(names[a]), ".json"))
+		nb, _ := strconv.Atoi(strings.TrimSuffix(filepath.Base(names[b]), ".json"))
+		return na < nb
+	})
+
+	r := &Replayer{
+		queues:  map[string][]string{},
+		lastHit: map[string]string{},
+		dir:     dir,
+	}
+
+	for _, name := range names {
+		i, err := readInteraction(name)
+		if err != nil {
+			return nil, err
+		}
+
+		base := strings.TrimSuffix(name, ".json")
+		r.queues[i.key()] = append(r.queues[i.key()], base)
+	}
+
+	return r, nil
+}
+
+// RoundTrip returns the next recorded response for the request.
+// Once all recorded responses for a request are used up,
+// the last one is repeated.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	i, err := newInteraction(req)
+	if err != nil {
+		return nil, err
+	}
+	key := i.key()
+
+	r.mu.Lock()
+	name := r.lastHit[key]
+	if queue := r.queues[key]; len(queue) > 0 {
+		name, r.queues[key] = queue[0], queue[1:]
+		r.lastHit[key] = name
+	}
+	r.mu.Unlock()
+
+	if name == "" {
+		return nil, fmt.Errorf("%w: %s %s", errNotRecorded, i.Method, i.URL)
+	}
+
+	recorded, err := readInteraction(name + ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := os.ReadFile(name + ".body")
+	if err != nil {
+		return nil, fmt.Errorf("could not read recording: %w", err)
+	}
+
+	header := recorded.Header
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(recorded.Status) + " " + http.StatusText(recorded.Status),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func readInteraction(name string) (*interaction, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read recording: %w", err)
+	}
+
+	var i interaction
+	if err := json.Unmarshal(content, &i); err != nil {
+		return nil, fmt.Errorf("could not unmarshal recording %s: %w", name, err)
+	}
+
+	return &i, nil
+}
+
+// newInteraction describes the request without secrets.
+// The form body is read and restored, so the request can still be sent.
+func newInteraction(req *http.Request) (*interaction, error) {
+	u := *req.URL
+	q := u.Query()
+	q.Del("token")
+	u.RawQuery = q.Encode()
+
+	i := &interaction{
+		Method: req.Method,
+		URL:    reToken.ReplaceAllString(u.String(), scrubbedToken),
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if req.Body == nil || mediaType != "application/x-www-form-urlencoded" {
+		return i, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read request: %w", err)
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse form: %w", err)
+	}
+	form.Del("token")
+	if len(form) > 0 {
+		i.Form = form
+	}
+
+	return i, nil
+}
+
+// scrubBody removes tokens and email addresses from text bodies.
+// Binary bodies, like downloaded images, are kept as they are.
+func (r *Recorder) scrubBody(body []byte, contentType string) []byte {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !strings.HasPrefix(mediaType, "text/") && mediaType != "application/json" {
+		return body
+	}
+
+	body = reToken.ReplaceAll(body, []byte(scrubbedToken))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// the same address gets the same placeholder, so users can still
+	// be told apart; numbers, unlike hashes, can't be matched against
+	// a list of known addresses
+	return reEmail.ReplaceAllFunc(body, func(email []byte) []byte {
+		address := strings.ToLower(string(email))
+		placeholder, ok := r.emails[address]
+		if !ok {
+			placeholder = fmt.Sprintf("user-%d@example.invalid", len(r.emails)+1)
+			r.emails[address] = placeholder
+		}
+		return []byte(placeholder)
+	})
+}
@@ -0,0 +1,114 @@
+package recording
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/exporter"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// testdata/export is a recording of exporting a single channel with
+// --download-files: two pages of history, a thread and an attachment.
+func TestReplayExport(t *testing.T) {
+	replayer, err := NewReplayer(filepath.Join("testdata", "export"))
+	if err != nil {
+		t.Fatalf("could not load recording: %v", err)
+	}
+
+	client := &http.Client{Transport: replayer}
+	api := slack.New("xoxp-replay", slack.OptionHTTPClient(client), slack.OptionAPIURL("https://slack.com/api/"))
+
+	output := t.TempDir()
+	e := exporter.New(api, "xoxp-replay", exporter.Options{
+		Output:        output,
+		DownloadFiles: true,
+		HTTPClient:    client,
+		NoRateLimit:   true,
+	})
+
+	if err := e.Export([]exporter.Target{{ID: "C0123456789"}}); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if err := e.Finish(); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+
+	data, err := structs.ReadFile(filepath.Join(output, "C0123456789.json"), nil)
+	if err != nil {
+		t.Fatalf("could not read export: %v", err)
+	}
+
+	var got []string
+	for _, m := range data.Messages {
+		got = append(got, m.Text)
+		for _, r := range m.Replies {
+			got = append(got, "  "+r.Text)
+		}
+	}
+
+	want := []string{
+		"Numbers are in the report, questions to user-1@example.invalid",
+		"Kickoff tomorrow?",
+		"  Yes, 10am",
+		"<@U0000000002> has joined the channel",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+
+	// the address in the message and the profile is the same person
+	if ann := data.Users["U0000000001"]; ann == nil || ann.Profile.Email != "user-1@example.invalid" {
+		t.Errorf("U0000000001 = %+v, want the placeholder of the message", ann)
+	}
+
+	filename, ok := data.Files["F0000000001"]
+	if !ok {
+		t.Fatalf("files = %v, want F0000000001", data.Files)
+	}
+
+	content, err := os.ReadFile(filepath.Join(output, "C0123456789", "F0000000001-"+filename))
+	if err != nil {
+		t.Fatalf("could not read attachment: %v", err)
+	}
+	if string(content) != "Q3 revenue: 42\n" {
+		t.Errorf("attachment = %q", content)
+	}
+}
+
+func TestReplayNotRecorded(t *testing.T) {
+	replayer, err := NewReplayer(filepath.Join("testdata", "export"))
+	if err != nil {
+		t.Fatalf("could not load recording: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://slack.com/api/emoji.list", nil)
+	if _, err := replayer.RoundTrip(req); err == nil {
+		t.Error("replayed a request that was not recorded")
+	}
+}
+
+func TestScrubBody(t *testing.T) {
+	r := &Recorder{emails: map[string]string{}}
+
+	got := string(r.scrubBody([]byte(`{"token":"xoxb-123-abc","a":"Ann@Example.com","b":"bob@example.com","c":"ann@example.com"}`), "application/json"))
+	want := `{"token":"xoxp-scrubbed","a":"user-1@example.invalid","b":"user-2@example.invalid","c":"user-1@example.invalid"}`
+	if got != want {
+		t.Errorf("scrubBody = %s, want %s", got, want)
+	}
+
+	// the numbering carries on across responses
+	if got := string(r.scrubBody([]byte("carol@example.com, bob@example.com"), "text/plain; charset=utf-8")); got != "user-3@example.invalid, user-2@example.invalid" {
+		t.Errorf("scrubBody of a second response = %s", got)
+	}
+
+	binary := []byte("\x89PNG ann@example.com")
+	if got := r.scrubBody(binary, "image/png"); string(got) != string(binary) {
+		t.Errorf("binary body was changed: %q", got)
+	}
+}
@@ -0,0 +1,332 @@
+// Package redact removes personal data from exported Slack data,
+// so that exports can be shared with third parties.
+//
+// User IDs and names are replaced with pseudonyms derived from a key,
+// so the same user gets the same pseudonym in every channel and every run
+// that uses the same key. Emails, phone numbers and custom patterns are masked
+// in message text and rich-text blocks, profiles are stripped
+// and references to downloaded files are dropped.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+const (
+	maskEmail   = "[email]"
+	maskPhone   = "[phone]"
+	maskPattern = "[redacted]"
+)
+
+var (
+	reEmail   = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	rePhone   = regexp.MustCompile(`(?:\+\d{1,3}[\s.\-]?)?(?:\(\d{2,4}\)|\d{2,4})[\s.\-]\d{3,4}[\s.\-]\d{3,4}`)
+	reMention = regexp.MustCompile(`<@([UW][A-Z0-9]+)(\|[^>]*)?>`)
+)
+
+// Redactor replaces personal data in structs.Data.
+type Redactor struct {
+	key      []byte
+	patterns []*regexp.Regexp
+}
+
+// New creates a Redactor.
+// Pseudonyms are derived from key; if key is empty, a random key is used
+// and pseudonyms are only stable within the lifetime of the Redactor.
+// Patterns are additional regular expressions to mask in message text.
+func New(key string, patterns []string) (*Redactor, error) {
+	r := &Redactor{key: []byte(key)}
+
+	if key == "" {
+		r.key = make([]byte, 32)
+		if _, err := rand.Read(r.key); err != nil {
+			return nil, fmt.Errorf("could not generate key: %w", err)
+		}
+	}
+
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("could not compile pattern %q: %w", p, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	return r, nil
+}
+
+func (r *Redactor) hash(id string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// UserID returns the stable pseudonym for the user ID.
+func (r *Redactor) UserID(id string) string {
+	if id == "" || id == "USLACKBOT" {
+		return id
+	}
+
+	return string(id[0]) + strings.ToUpper(r.hash(id)[:10])
+}
+
+// Name returns the stable display name for the user ID.
+func (r *Redactor) Name(id string) string {
+	return "user-" + r.hash(id)[:6]
+}
+
+// Text replaces user mentions with pseudonyms
+// and masks emails, phone numbers and custom patterns.
+func (r *Redactor) Text(s string) string {
+	if s == "" {
+		return s
+	}
+
+	s = reMention.ReplaceAllStringFunc(s, func(m string) string {
+		return "<@" + r.UserID(reMention.FindStringSubmatch(m)[1]) + ">"
+	})
+	s = reEmail.ReplaceAllString(s, maskEmail)
+	s = rePhone.ReplaceAllString(s, maskPhone)
+
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, maskPattern)
+	}
+
+	return s
+}
+
+// Data redacts the channel, messages, earlier versions of edited messages
+// and users in place and drops references to downloaded files.
+func (r *Redactor) Data(d *structs.Data) {
+	r.Channel(&d.Channel)
+
+	for i := range d.Messages {
+		r.Message(&d.Messages[i].Message)
+		r.Messages(d.Messages[i].Replies)
+	}
+
+	for _, h := range d.History {
+		for i := range h.Versions {
+			h.Versions[i].Text = r.Text(h.Versions[i].Text)
+		}
+	}
+
+	d.Users = r.Users(d.Users)
+	d.Files = nil
+}
+
+// Channel redacts the channel members, creator and descriptions.
+func (r *Redactor) Channel(c *slack.Channel) {
+	c.User = r.UserID(c.User)
+	c.Creator = r.UserID(c.Creator)
+	c.Topic.Creator = r.UserID(c.Topic.Creator)
+	c.Topic.Value = r.Text(c.Topic.Value)
+	c.Purpose.Creator = r.UserID(c.Purpose.Creator)
+	c.Purpose.Value = r.Text(c.Purpose.Value)
+
+	for i, member := range c.Members {
+		c.Members[i] = r.UserID(member)
+	}
+
+	if c.IsMpIM {
+		// group DM names and purposes are made of user names
+		names := make([]string, 0, len(c.Members))
+		for _, member := range c.Members {
+			names = append(names, "@"+r.Name(member))
+		}
+
+		c.Name = "mpdm-" + strings.ToLower(r.hash(c.ID)[:10])
+		c.NameNormalized = c.Name
+		c.Purpose.Value = "Group messaging with: " + strings.Join(names, " ")
+	}
+}
+
+// Messages redacts the messages in place.
+func (r *Redactor) Messages(msgs []slack.Message) {
+	for i := range msgs {
+		r.Message(&msgs[i])
+	}
+}
+
+// Message redacts a single message in place.
+func (r *Redactor) Message(m *slack.Message) {
+	r.msg(&m.Msg)
+
+	if m.SubMessage != nil {
+		r.msg(m.SubMessage)
+	}
+
+	if m.PreviousMessage != nil {
+		r.msg(m.PreviousMessage)
+	}
+}
+
+func (r *Redactor) msg(m *slack.Msg) {
+	if m.User != "" && m.BotID == "" {
+		m.Username = ""
+	}
+
+	m.User = r.UserID(m.User)
+	m.Inviter = r.UserID(m.Inviter)
+	m.ParentUserId = r.UserID(m.ParentUserId)
+	m.Text = r.Text(m.Text)
+	m.Topic = r.Text(m.Topic)
+	m.Purpose = r.Text(m.Purpose)
+	m.Permalink = ""
+
+	if m.Edited != nil {
+		m.Edited.User = r.UserID(m.Edited.User)
+	}
+
+	for i, user := range m.ReplyUsers {
+		m.ReplyUsers[i] = r.UserID(user)
+	}
+
+	for i, member := range m.Members {
+		m.Members[i] = r.UserID(member)
+	}
+
+	for i := range m.Replies {
+		m.Replies[i].User = r.UserID(m.Replies[i].User)
+	}
+
+	for i := range m.Reactions {
+		for j, user := range m.Reactions[i].Users {
+			m.Reactions[i].Users[j] = r.UserID(user)
+		}
+	}
+
+	for i := range m.Attachments {
+		a := &m.Attachments[i]
+		a.Pretext = r.Text(a.Pretext)
+		a.Text = r.Text(a.Text)
+		a.Fallback = r.Text(a.Fallback)
+		a.AuthorName = r.Text(a.AuthorName)
+		a.AuthorIcon = ""
+		a.AuthorLink = ""
+	}
+
+	for i := range m.Files {
+		r.file(&m.Files[i])
+	}
+
+	r.Blocks(m.Blocks)
+}
+
+// file keeps the file metadata, but drops its contents and links.
+func (r *Redactor) file(f *slack.File) {
+	*f = slack.File{
+		ID:         f.ID,
+		Created:    f.Created,
+		Timestamp:  f.Timestamp,
+		Name:       r.Text(f.Name),
+		Title:      r.Text(f.Title),
+		Mimetype:   f.Mimetype,
+		Filetype:   f.Filetype,
+		PrettyType: f.PrettyType,
+		User:       r.UserID(f.User),
+		Size:       f.Size,
+		OriginalW:  f.OriginalW,
+		OriginalH:  f.OriginalH,
+	}
+}
+
+// Blocks redacts the text and user mentions in the blocks in place.
+func (r *Redactor) Blocks(blocks slack.Blocks) {
+	for _, block := range blocks.BlockSet {
+		switch b := block.(type) {
+		case *slack.RichTextBlock:
+			r.richTextElements(b.Elements)
+		case *slack.SectionBlock:
+			if b.Text != nil {
+				b.Text.Text = r.Text(b.Text.Text)
+			}
+			for _, field := range b.Fields {
+				field.Text = r.Text(field.Text)
+			}
+		case *slack.ContextBlock:
+			for _, element := range b.ContextElements.Elements {
+				if text, ok := element.(*slack.TextBlockObject); ok {
+					text.Text = r.Text(text.Text)
+				}
+			}
+		}
+	}
+}
+
+func (r *Redactor) richTextElements(elements []slack.RichTextElement) {
+	for _, element := range elements {
+		switch e := element.(type) {
+		case *slack.RichTextSection:
+			r.richTextSectionElements(e.Elements)
+		case *slack.RichTextQuote:
+			r.richTextSectionElements(e.Elements)
+		case *slack.RichTextPreformatted:
+			r.richTextSectionElements(e.Elements)
+		case *slack.RichTextList:
+			r.richTextElements(e.Elements)
+		}
+	}
+}
+
+func (r *Redactor) richTextSectionElements(elements []slack.RichTextSectionElement) {
+	for _, element := range elements {
+		switch e := element.(type) {
+		case *slack.RichTextSectionTextElement:
+			e.Text = r.Text(e.Text)
+		case *slack.RichTextSectionUserElement:
+			e.UserID = r.UserID(e.UserID)
+		case *slack.RichTextSectionLinkElement:
+			e.Text = r.Text(e.Text)
+			e.URL = r.Text(e.URL)
+		}
+	}
+}
+
+// Users returns a copy of the users keyed by pseudonyms,
+// with the profiles stripped down to pseudonymous names.
+// The original users are not modified, as they may be cached.
+func (r *Redactor) Users(users map[string]*slack.User) map[string]*slack.User {
+	if users == nil {
+		return nil
+	}
+
+	result := make(map[string]*slack.User, len(users))
+	for id, user := range users {
+		if user == nil {
+			continue
+		}
+
+		name := r.Name(id)
+		redacted := &slack.User{
+			ID:        r.UserID(user.ID),
+			TeamID:    user.TeamID,
+			Name:      name,
+			RealName:  name,
+			Deleted:   user.Deleted,
+			IsBot:     user.IsBot,
+			IsAppUser: user.IsAppUser,
+			Profile: slack.UserProfile{
+				RealName:              name,
+				RealNameNormalized:    name,
+				DisplayName:           name,
+				DisplayNameNormalized: name,
+				Team:                  user.Profile.Team,
+			},
+		}
+
+		result[r.UserID(id)] = redacted
+	}
+
+	return result
+}
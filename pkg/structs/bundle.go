@@ -0,0 +1,490 @@
+package structs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bundle formats, also used as file extensions.
+const (
+	BundleZip   = "zip"
+	BundleTarGz = "tar.gz"
+
+	// ManifestName is the name of the manifest file in the output directory.
+	ManifestName = "manifest.json"
+)
+
+var errUnknownBundle = errors.New("unknown bundle format")
+
+// Manifest lists the files of an export, written next to them
+// so that a bundle can be checked after it has been moved around.
+type Manifest struct {
+	Created  time.Time      `json:"created"`
+	Channels []string       `json:"channels"`
+	Files    []ManifestFile `json:"files"`
+}
+
+// ManifestFile describes a single file of an export.
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// IsDataFile reports whether the file name is an exported channel:
+// plain or gzip-compressed JSON.
+func IsDataFile(name string) bool {
+	return strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz")
+}
+
+// TrimDataExt returns the file name without the .json or .json.gz extension.
+func TrimDataExt(name string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".json")
+}
+
+// IsBundle reports whether the path is a bundle, judging by its extension.
+func IsBundle(p string) bool {
+	return strings.HasSuffix(p, "."+BundleZip) || strings.HasSuffix(p, "."+BundleTarGz)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// OpenDir opens an export directory or bundle as a file system.
+// The returned closer must be closed when done.
+func OpenDir(p string) (fs.FS, io.Closer, error) {
+	switch {
+	case strings.HasSuffix(p, "."+BundleZip):
+		r, err := zip.OpenReader(p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not open zip bundle: %w", err)
+		}
+		return r, r, nil
+	case strings.HasSuffix(p, "."+BundleTarGz):
+		fsys, err := readTarGz(p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not open tar.gz bundle: %w", err)
+		}
+		return fsys, fsys, nil
+	default:
+		return os.DirFS(p), nopCloser{}, nil
+	}
+}
+
+// NewManifest lists all files in dir, except the manifest itself.
+func NewManifest(dir string, channels []string) (*Manifest, error) {
+	m := &Manifest{Created: time.Now().UTC(), Channels: channels}
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+		if rel == ManifestName {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		h := sha256.New()
+		size, err := io.Copy(h, f)
+		if err != nil {
+			return err
+		}
+
+		m.Files = append(m.Files, ManifestFile{Path: rel, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list files: %w", err)
+	}
+
+	return m, nil
+}
+
+// WriteBundle packs all files in dir into a single archive at dst.
+// The format is chosen by the extension of dst.
+func WriteBundle(dir, dst string) error {
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not create bundle: %w", err)
+	}
+	defer f.Close()
+
+	switch {
+	case strings.HasSuffix(dst, "."+BundleZip):
+		err = writeZip(dir, f)
+	case strings.HasSuffix(dst, "."+BundleTarGz):
+		err = writeTarGz(dir, f)
+	default:
+		err = fmt.Errorf("%w: %q", errUnknownBundle, dst)
+	}
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// ExtractAssets copies the files in subdirectories of the bundle,
+// attachments and avatars, so that converted files can reference them.
+// Exported channels at the top level, or at the top of
+// an Enterprise Grid workspace directory, are not copied.
+func ExtractAssets(input fs.FS, output string) error {
+	workspaces, err := ReadWorkspaces(input)
+	if err != nil {
+		return err
+	}
+
+	return fs.WalkDir(input, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.Contains(p, "/") {
+			return err
+		}
+
+		if dir := path.Dir(p); IsDataFile(p) && workspaces != nil && workspaces.Find(dir) >= 0 {
+			return nil
+		}
+
+		dst := filepath.Join(output, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return fmt.Errorf("could not create directory: %w", err)
+		}
+
+		src, err := input.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		f, err := os.Create(dst)
+		if err != nil {
+			return fmt.Errorf("could not create file: %w", err)
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(f, src); err != nil {
+			return fmt.Errorf("could not copy %q: %w", p, err)
+		}
+
+		return f.Close()
+	})
+}
+
+func walkFiles(dir string, fn func(rel string, info fs.FileInfo, f *os.File) error) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return fn(filepath.ToSlash(rel), info, f)
+	})
+}
+
+func writeZip(dir string, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	err := walkFiles(dir, func(rel string, info fs.FileInfo, f *os.File) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+		header.Method = zip.Deflate
+
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(fw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not write zip bundle: %w", err)
+	}
+
+	return zw.Close()
+}
+
+func writeTarGz(dir string, w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	err := walkFiles(dir, func(rel string, info fs.FileInfo, f *os.File) error {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not write tar.gz bundle: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
+
+// tarFS is a read-only file system with the content of a tar.gz bundle.
+// Exported channels and other JSON files are loaded when the bundle is opened.
+// Tar files can't be read at random, so other files, like attachments,
+// are read when opened by scanning the bundle from the last file read,
+// or from the start if it is behind; files are usually opened in order.
+type tarFS struct {
+	path  string
+	files map[string]*tarEntry
+
+	mu     sync.Mutex
+	f      *os.File
+	tr     *tar.Reader
+	cursor int // index of the next header tr returns
+}
+
+type tarEntry struct {
+	name    string
+	data    []byte
+	size    int64
+	index   int // index of the header in the bundle, for lazy entries
+	lazy    bool
+	modTime time.Time
+	dir     bool
+	entries []fs.DirEntry
+}
+
+func readTarGz(p string) (*tarFS, error) {
+	fsys := &tarFS{path: p, files: map[string]*tarEntry{".": {name: ".", dir: true}}}
+	if err := fsys.rewind(); err != nil {
+		return nil, err
+	}
+
+	for {
+		index := fsys.cursor
+		header, err := fsys.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			fsys.Close()
+			return nil, err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		e := &tarEntry{size: header.Size, index: index, modTime: header.ModTime, lazy: true}
+		if IsDataFile(header.Name) {
+			if e.data, err = io.ReadAll(fsys.tr); err != nil {
+				fsys.Close()
+				return nil, err
+			}
+			e.lazy = false
+		}
+
+		fsys.add(path.Clean(header.Name), e)
+	}
+
+	for _, e := range fsys.files {
+		sort.Slice(e.entries, func(i, j int) bool { return e.entries[i].Name() < e.entries[j].Name() })
+	}
+
+	return fsys, nil
+}
+
+// rewind reopens the bundle at the first header.
+func (t *tarFS) rewind() error {
+	if t.f != nil {
+		t.f.Close()
+	}
+
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	t.f, t.tr, t.cursor = f, tar.NewReader(gr), 0
+	return nil
+}
+
+func (t *tarFS) next() (*tar.Header, error) {
+	header, err := t.tr.Next()
+	if err == nil {
+		t.cursor++
+	}
+	return header, err
+}
+
+// read returns the content of a lazy entry.
+func (t *tarFS) read(e *tarEntry) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.f == nil || t.cursor > e.index {
+		if err := t.rewind(); err != nil {
+			return nil, err
+		}
+	}
+
+	for t.cursor <= e.index {
+		if _, err := t.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	return io.ReadAll(t.tr)
+}
+
+// Close closes the bundle.
+func (t *tarFS) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.f == nil {
+		return nil
+	}
+
+	err := t.f.Close()
+	t.f = nil
+	return err
+}
+
+// add stores the file and creates its parent directories.
+func (t *tarFS) add(name string, e *tarEntry) {
+	e.name = path.Base(name)
+	t.files[name] = e
+
+	for name != "." {
+		parent := path.Dir(name)
+		dir, ok := t.files[parent]
+		if !ok {
+			dir = &tarEntry{name: path.Base(parent), dir: true}
+			t.files[parent] = dir
+		}
+
+		dir.entries = append(dir.entries, fs.FileInfoToDirEntry(e.info()))
+		if ok {
+			return
+		}
+
+		name, e = parent, dir
+	}
+}
+
+func (t *tarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	e, ok := t.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	data := e.data
+	if e.lazy {
+		var err error
+		if data, err = t.read(e); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+
+	return &tarFile{entry: e, Reader: bytes.NewReader(data)}, nil
+}
+
+type tarFile struct {
+	*bytes.Reader
+	entry  *tarEntry
+	offset int
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.entry.info(), nil }
+func (f *tarFile) Close() error               { return nil }
+
+func (f *tarFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries := f.entry.entries[f.offset:]
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	f.offset += len(entries)
+
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+
+	return entries, nil
+}
+
+func (e *tarEntry) info() fs.FileInfo { return tarInfo{e} }
+
+type tarInfo struct{ e *tarEntry }
+
+func (i tarInfo) Name() string       { return i.e.name }
+func (i tarInfo) Size() int64        { return i.e.size }
+func (i tarInfo) ModTime() time.Time { return i.e.modTime }
+func (i tarInfo) IsDir() bool        { return i.e.dir }
+func (i tarInfo) Sys() any           { return nil }
+
+func (i tarInfo) Mode() fs.FileMode {
+	if i.e.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
@@ -0,0 +1,75 @@
+package structs
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTarGzBundle(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"C1.json":            `{"channel":{"id":"C1"}}`,
+		"C1/F1-report.pdf":   "first attachment",
+		"C1/F2-contract.pdf": "second attachment",
+		"avatars/U1.png":     "avatar",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bundle := filepath.Join(t.TempDir(), "export.tar.gz")
+	if err := WriteBundle(dir, bundle); err != nil {
+		t.Fatalf("could not write bundle: %v", err)
+	}
+
+	fsys, closer, err := OpenDir(bundle)
+	if err != nil {
+		t.Fatalf("could not open bundle: %v", err)
+	}
+	defer closer.Close()
+
+	// channels are loaded, attachments are read when opened
+	tar := fsys.(*tarFS)
+	if e := tar.files["C1.json"]; e.lazy || e.data == nil {
+		t.Error("channel file was not loaded when the bundle was opened")
+	}
+	if e := tar.files["C1/F1-report.pdf"]; !e.lazy || e.data != nil {
+		t.Error("attachment was loaded when the bundle was opened")
+	}
+
+	// out of order, so the bundle has to be read again from the start
+	for _, name := range []string{"C1/F2-contract.pdf", "avatars/U1.png", "C1/F1-report.pdf", "C1.json", "C1/F2-contract.pdf"} {
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Fatalf("could not read %s: %v", name, err)
+		}
+		if string(content) != files[name] {
+			t.Errorf("%s = %q, want %q", name, content, files[name])
+		}
+
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatalf("could not stat %s: %v", name, err)
+		}
+		if info.Size() != int64(len(files[name])) {
+			t.Errorf("%s size = %d, want %d", name, info.Size(), len(files[name]))
+		}
+	}
+
+	entries, err := fs.ReadDir(fsys, "C1")
+	if err != nil || len(entries) != 2 || entries[0].Name() != "F1-report.pdf" {
+		t.Errorf("ReadDir(C1) = %v, %v", entries, err)
+	}
+
+	if _, err := fsys.Open("C1/missing.pdf"); err == nil {
+		t.Error("opened a file that is not in the bundle")
+	}
+}
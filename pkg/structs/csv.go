@@ -0,0 +1,126 @@
+package structs
+
+import (
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSVHeader lists the columns written by CSVWriter.
+var CSVHeader = []string{
+	"channel",
+	"channel_id",
+	"ts",
+	"time",
+	"author",
+	"author_id",
+	"thread_ts",
+	"text",
+	"files",
+	"reactions",
+	"edited",
+	"permalink",
+}
+
+// CSVWriter writes messages as CSV, one row per message, for spreadsheets.
+type CSVWriter struct {
+	w            *csv.Writer
+	location     *time.Location
+	workspaceURL string
+	header       bool
+}
+
+// NewCSVWriter returns a writer that formats times in the location
+// and builds permalinks from the workspace URL, like https://example.slack.com/.
+// Permalinks are left empty if the workspace URL is not known.
+func NewCSVWriter(w io.Writer, location *time.Location, workspaceURL string) *CSVWriter {
+	if location == nil {
+		location = time.UTC
+	}
+
+	return &CSVWriter{
+		w:            csv.NewWriter(w),
+		location:     location,
+		workspaceURL: workspaceURL,
+	}
+}
+
+// Write writes the messages of the channel, preceded by the header
+// if nothing has been written yet.
+func (cw *CSVWriter) Write(d *Data) error {
+	if !cw.header {
+		if err := cw.w.Write(CSVHeader); err != nil {
+			return err
+		}
+		cw.header = true
+	}
+
+	// file paths are not part of the rows
+	for _, line := range Lines(d, "") {
+		files := make([]string, 0, len(line.Files))
+		for _, f := range line.Files {
+			files = append(files, csvCell(cmp.Or(f.Name, f.Title, f.ID)))
+		}
+
+		reactions := make([]string, 0, len(line.Reactions))
+		for _, r := range line.Reactions {
+			reactions = append(reactions, fmt.Sprintf(":%s: %d", r.Name, r.Count))
+		}
+
+		record := []string{
+			csvCell(line.ChannelName),
+			line.ChannelID,
+			line.TS,
+			line.Time.In(cw.location).Format(time.RFC3339),
+			csvCell(line.UserName),
+			line.UserID,
+			line.ThreadTS,
+			csvCell(line.Text),
+			strings.Join(files, "; "),
+			strings.Join(reactions, "; "),
+			strconv.FormatBool(line.Edited),
+			Permalink(cw.workspaceURL, line.ChannelID, line.TS, line.ThreadTS),
+		}
+
+		if err := cw.w.Write(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// csvCell keeps spreadsheets from evaluating the text as a formula
+// by prefixing it with a quote if it starts like one.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+
+	return s
+}
+
+// Flush writes any buffered rows and reports a write error, if any.
+func (cw *CSVWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// Permalink builds the link to a message the same way Slack does,
+// or returns an empty string if the workspace URL is not known.
+func Permalink(workspaceURL, channelID, ts, threadTS string) string {
+	if workspaceURL == "" {
+		return ""
+	}
+
+	link := strings.TrimSuffix(workspaceURL, "/") + "/archives/" + channelID + "/p" + strings.ReplaceAll(ts, ".", "")
+	if threadTS != "" && threadTS != ts {
+		link += "?thread_ts=" + threadTS + "&cid=" + channelID
+	}
+
+	return link
+}
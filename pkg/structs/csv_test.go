@@ -0,0 +1,70 @@
+package structs
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+func TestCSVWriterEscapesFormulas(t *testing.T) {
+	d := &Data{
+		Channel: slack.Channel{GroupConversation: slack.GroupConversation{
+			Conversation: slack.Conversation{ID: "C1"},
+			Name:         "general",
+		}},
+		Messages: []Message{
+			{Message: slack.Message{Msg: slack.Msg{
+				Timestamp: "1700000000.000100",
+				Username:  "@bot",
+				Text:      "=HYPERLINK(\"https://example.com\")",
+				Files:     []slack.File{{ID: "F1", Name: "+report.csv"}, {ID: "F2", Name: "-notes.txt"}},
+			}}},
+			{Message: slack.Message{Msg: slack.Msg{
+				Timestamp: "1700000000.000200",
+				Username:  "bot",
+				Text:      "2 + 2 = 4",
+			}}},
+		},
+	}
+
+	sb := &strings.Builder{}
+	cw := NewCSVWriter(sb, nil, "")
+	if err := cw.Write(d); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+	if err := cw.Flush(); err != nil {
+		t.Fatalf("could not flush: %v", err)
+	}
+
+	records, err := csv.NewReader(strings.NewReader(sb.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("could not read CSV: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+
+	column := map[string]int{}
+	for i, name := range CSVHeader {
+		column[name] = i
+	}
+
+	tests := []struct {
+		row    int
+		column string
+		want   string
+	}{
+		{1, "author", "'@bot"},
+		{1, "text", "'=HYPERLINK(\"https://example.com\")"},
+		{1, "files", "'+report.csv; '-notes.txt"},
+		{2, "author", "bot"},
+		{2, "text", "2 + 2 = 4"},
+	}
+	for _, tt := range tests {
+		if got := records[tt.row][column[tt.column]]; got != tt.want {
+			t.Errorf("row %d, %s = %q, want %q", tt.row, tt.column, got, tt.want)
+		}
+	}
+}
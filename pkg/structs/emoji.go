@@ -0,0 +1,47 @@
+package structs
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EmojiFile is the name of the custom emoji list written by cmd/emoji.
+const EmojiFile = "emoji.json"
+
+// EmojiMap maps custom emoji names to their image URLs or "alias:<name>".
+type EmojiMap map[string]string
+
+// LoadEmoji reads the custom emoji list from the emoji directory.
+func LoadEmoji(dir string) (EmojiMap, error) {
+	f, err := os.Open(filepath.Join(dir, EmojiFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var m EmojiMap
+	err = json.NewDecoder(f).Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Get returns the emoji the name is an alias of,
+// or the name of its downloaded image file.
+func (m EmojiMap) Get(needle string) (alias, filename string) {
+	e, ok := m[needle]
+	if !ok {
+		return "", ""
+	}
+
+	if strings.HasPrefix(e, "alias:") {
+		return strings.TrimPrefix(e, "alias:"), ""
+	}
+
+	ext := filepath.Ext(e)
+	return "", needle + ext
+}
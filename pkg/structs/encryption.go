@@ -0,0 +1,331 @@
+package structs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Encrypted files start with a header:
+//
+//	magic (8 bytes) | kdf (1 byte) | kdf salt (16 bytes) | file nonce (16 bytes)
+//
+// followed by AES-256-GCM sealed chunks of chunkSize plaintext bytes.
+// The last chunk is shorter than chunkSize (possibly empty) and is sealed
+// with the final flag set in its nonce, so truncated files are detected.
+// Every file is encrypted with its own key, derived from the master key
+// and the file nonce with HMAC-SHA256.
+const (
+	encryptionMagic = "SLKXENC1"
+	kdfPassphrase   = 1
+	kdfKeyFile      = 2
+	saltSize        = 16
+	headerSize      = len(encryptionMagic) + 1 + saltSize + saltSize
+	chunkSize       = 64 * 1024
+	keySize         = 32
+
+	// pbkdf2Iterations follows the OWASP recommendation for PBKDF2-HMAC-SHA256.
+	pbkdf2Iterations = 600_000
+)
+
+var (
+	errMissingSecret = errors.New("file is encrypted, passphrase or key file is required")
+	errInvalidKey    = errors.New("key file must contain 32 bytes or 64 hex characters")
+	errInvalidHeader = errors.New("invalid encryption header")
+	errWrongKDF      = errors.New("file was encrypted with a different kind of secret")
+	errTruncated     = errors.New("encrypted file is truncated")
+)
+
+// Secret is a passphrase or a key used to encrypt and decrypt export files.
+type Secret struct {
+	kdf        byte
+	passphrase []byte
+	key        []byte
+
+	mu      sync.Mutex
+	salt    []byte            // salt used for writing, generated once
+	derived map[string][]byte // salt -> master key
+}
+
+// NewPassphrase returns a Secret that derives keys from the passphrase.
+func NewPassphrase(passphrase string) *Secret {
+	return &Secret{
+		kdf:        kdfPassphrase,
+		passphrase: []byte(passphrase),
+		derived:    make(map[string][]byte),
+	}
+}
+
+// ReadKeyFile returns a Secret with the key read from the file.
+// The file must contain 32 random bytes, or 64 hex characters.
+func ReadKeyFile(path string) (*Secret, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file: %w", err)
+	}
+
+	key := content
+	if len(key) != keySize {
+		key, err = hex.DecodeString(strings.TrimSpace(string(content)))
+		if err != nil || len(key) != keySize {
+			return nil, errInvalidKey
+		}
+	}
+
+	return &Secret{kdf: kdfKeyFile, key: key, derived: make(map[string][]byte)}, nil
+}
+
+// LoadSecret returns the Secret for the command line options:
+// nil if neither is set, meaning files are not encrypted.
+func LoadSecret(passphrase, keyFile string) (*Secret, error) {
+	switch {
+	case keyFile != "":
+		return ReadKeyFile(keyFile)
+	case passphrase != "":
+		return NewPassphrase(passphrase), nil
+	default:
+		return nil, nil
+	}
+}
+
+func (s *Secret) masterKey(salt []byte) []byte {
+	if s.kdf == kdfKeyFile {
+		return s.key
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.derived[string(salt)]; ok {
+		return key
+	}
+
+	key := pbkdf2SHA256(s.passphrase, salt, pbkdf2Iterations, keySize)
+	s.derived[string(salt)] = key
+	return key
+}
+
+func (s *Secret) writeSalt() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.salt == nil {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("could not generate salt: %w", err)
+		}
+		s.salt = salt
+	}
+
+	return s.salt, nil
+}
+
+func (s *Secret) fileCipher(salt, nonce []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, s.masterKey(salt))
+	mac.Write(nonce)
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Encrypt returns a writer that encrypts everything written to it into w.
+// Close must be called to write the final chunk; it does not close w.
+func (s *Secret) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	salt, err := s.writeSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, saltSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("could not generate nonce: %w", err)
+	}
+
+	aead, err := s.fileCipher(salt, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, encryptionMagic...)
+	header = append(header, s.kdf)
+	header = append(header, salt...)
+	header = append(header, nonce...)
+
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("could not write header: %w", err)
+	}
+
+	return &encryptWriter{w: w, aead: aead}, nil
+}
+
+// Decrypt returns a reader that decrypts r.
+// r must start with the encryption header.
+func (s *Secret) Decrypt(r io.Reader) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("could not read header: %w", err)
+	}
+
+	if string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, errInvalidHeader
+	}
+
+	rest := header[len(encryptionMagic):]
+	if rest[0] != s.kdf {
+		return nil, errWrongKDF
+	}
+
+	aead, err := s.fileCipher(rest[1:1+saltSize], rest[1+saltSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		r:     r,
+		aead:  aead,
+		chunk: make([]byte, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+func chunkNonce(counter uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	ew.buf = append(ew.buf, p...)
+
+	// full chunks are never final, the final chunk is always shorter
+	for len(ew.buf) >= chunkSize {
+		if err := ew.seal(ew.buf[:chunkSize], false); err != nil {
+			return 0, err
+		}
+		ew.buf = ew.buf[chunkSize:]
+	}
+
+	return len(p), nil
+}
+
+func (ew *encryptWriter) Close() error {
+	return ew.seal(ew.buf, true)
+}
+
+func (ew *encryptWriter) seal(plaintext []byte, final bool) error {
+	sealed := ew.aead.Seal(nil, chunkNonce(ew.counter, final), plaintext, nil)
+	ew.counter++
+
+	if _, err := ew.w.Write(sealed); err != nil {
+		return fmt.Errorf("could not write chunk: %w", err)
+	}
+
+	return nil
+}
+
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	chunk   []byte
+	buf     []byte
+	counter uint64
+	done    bool
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+
+		if err := dr.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+func (dr *decryptReader) open() error {
+	n, err := io.ReadFull(dr.r, dr.chunk)
+
+	final := false
+	switch {
+	case err == nil:
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		final = true
+	case errors.Is(err, io.EOF):
+		return errTruncated
+	default:
+		return fmt.Errorf("could not read chunk: %w", err)
+	}
+
+	plaintext, err := dr.aead.Open(nil, chunkNonce(dr.counter, final), dr.chunk[:n], nil)
+	if err != nil {
+		return fmt.Errorf("could not decrypt chunk: %w", err)
+	}
+
+	dr.counter++
+	dr.buf = plaintext
+	dr.done = final
+	return nil
+}
+
+// pbkdf2SHA256 implements PBKDF2 (RFC 8018) with HMAC-SHA256.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	var counter [4]byte
+	dk := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		dk = prf.Sum(dk)
+
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		for i := 2; i <= iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for x := range u {
+				t[x] ^= u[x]
+			}
+		}
+	}
+
+	return dk[:keyLen]
+}
@@ -0,0 +1,171 @@
+package structs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// RFC 6070 inputs with HMAC-SHA256, the last one from RFC 7914
+	tests := []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9"},
+		{"pass\x00word", "sa\x00lt", 4096, "89b69d0516f829893c696226650a8687"},
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+	}
+
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iterations, len(tt.want)/2))
+		if got != tt.want {
+			t.Errorf("pbkdf2SHA256(%q, %q, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	secret := testKey(t)
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize} {
+		plaintext := randomBytes(t, size)
+
+		got, err := io.ReadAll(mustDecrypt(t, secret, encrypt(t, secret, plaintext)))
+		if err != nil {
+			t.Fatalf("size %d: could not decrypt: %v", size, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("size %d: decrypted %d bytes that differ from the plaintext", size, len(got))
+		}
+	}
+}
+
+func TestEncryptPassphrase(t *testing.T) {
+	plaintext := []byte(`{"messages":[]}`)
+	encrypted := encrypt(t, NewPassphrase("correct horse"), plaintext)
+
+	got, err := io.ReadAll(mustDecrypt(t, NewPassphrase("correct horse"), encrypted))
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("could not decrypt with the same passphrase: %q, %v", got, err)
+	}
+
+	if _, err := io.ReadAll(mustDecrypt(t, NewPassphrase("battery staple"), encrypted)); err == nil {
+		t.Error("decrypted with a wrong passphrase")
+	}
+
+	if _, err := testKey(t).Decrypt(bytes.NewReader(encrypted)); !errors.Is(err, errWrongKDF) {
+		t.Errorf("decrypting a passphrase file with a key file: got %v, want %v", err, errWrongKDF)
+	}
+}
+
+func TestDecryptWrongKey(t *testing.T) {
+	encrypted := encrypt(t, testKey(t), []byte("secret"))
+
+	if _, err := io.ReadAll(mustDecrypt(t, testKey(t), encrypted)); err == nil {
+		t.Error("decrypted with a wrong key")
+	}
+}
+
+func TestDecryptTruncated(t *testing.T) {
+	secret := testKey(t)
+
+	for _, size := range []int{chunkSize, chunkSize + 1, 2 * chunkSize} {
+		encrypted := encrypt(t, secret, randomBytes(t, size))
+
+		// cut after the last full chunk, dropping the final one
+		boundary := headerSize + size/chunkSize*(chunkSize+16)
+		_, err := io.ReadAll(mustDecrypt(t, secret, encrypted[:boundary]))
+		if !errors.Is(err, errTruncated) {
+			t.Errorf("size %d cut at a chunk boundary: got %v, want %v", size, err, errTruncated)
+		}
+
+		// cut inside a chunk
+		if _, err := io.ReadAll(mustDecrypt(t, secret, encrypted[:boundary-1])); err == nil {
+			t.Errorf("size %d cut inside a chunk: decrypted without error", size)
+		}
+	}
+}
+
+func TestDecryptSwappedChunks(t *testing.T) {
+	secret := testKey(t)
+	encrypted := encrypt(t, secret, randomBytes(t, 2*chunkSize+10))
+
+	sealed := chunkSize + 16
+	first := encrypted[headerSize : headerSize+sealed]
+	second := encrypted[headerSize+sealed : headerSize+2*sealed]
+
+	swapped := append([]byte{}, encrypted[:headerSize]...)
+	swapped = append(swapped, second...)
+	swapped = append(swapped, first...)
+	swapped = append(swapped, encrypted[headerSize+2*sealed:]...)
+
+	if _, err := io.ReadAll(mustDecrypt(t, secret, swapped)); err == nil {
+		t.Error("decrypted a file with swapped chunks")
+	}
+}
+
+func testKey(t *testing.T) *Secret {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(randomBytes(t, keySize))+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := ReadKeyFile(path)
+	if err != nil {
+		t.Fatalf("could not read key file: %v", err)
+	}
+
+	return secret
+}
+
+func encrypt(t *testing.T, secret *Secret, plaintext []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := secret.Encrypt(&buf)
+	if err != nil {
+		t.Fatalf("could not encrypt: %v", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("could not close: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func mustDecrypt(t *testing.T, secret *Secret, encrypted []byte) io.Reader {
+	t.Helper()
+
+	r, err := secret.Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatalf("could not read header: %v", err)
+	}
+
+	return r
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
@@ -0,0 +1,246 @@
+package structs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const gzipMagic = "\x1f\x8b"
+
+// OpenReader returns a reader over the plain content of r,
+// decrypting it with the secret if it is encrypted.
+func OpenReader(r io.Reader, secret *Secret) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	// Peek returns fewer bytes for files shorter than the magic
+	if magic, _ := br.Peek(len(encryptionMagic)); string(magic) != encryptionMagic {
+		return br, nil
+	}
+
+	if secret == nil {
+		return nil, errMissingSecret
+	}
+
+	return secret.Decrypt(br)
+}
+
+// IsEncrypted reports whether the file starts with the encryption header.
+func IsEncrypted(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(encryptionMagic))
+	n, err := io.ReadFull(f, magic)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+
+	return string(magic[:n]) == encryptionMagic, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Open opens the file for reading, decrypting it if needed.
+func Open(path string, secret *Secret) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := OpenReader(f, secret)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not open %q: %w", path, err)
+	}
+
+	return readCloser{Reader: r, Closer: f}, nil
+}
+
+// OpenData returns a reader over the plain JSON of an exported channel,
+// decrypting and decompressing r if needed.
+func OpenData(r io.Reader, secret *Secret) (io.Reader, error) {
+	r, err := OpenReader(r, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(gzipMagic)); string(magic) != gzipMagic {
+		return br, nil
+	}
+
+	gr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress data: %w", err)
+	}
+
+	return gr, nil
+}
+
+// ReadData decodes an exported channel from r,
+// which may be encrypted and gzip-compressed.
+// Older documents are upgraded to the current schema.
+func ReadData(r io.Reader, secret *Secret) (*Data, error) {
+	r, err := OpenData(r, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read data: %w", err)
+	}
+
+	data, _, err := DecodeData(content)
+	return data, err
+}
+
+// ReadFile reads and decodes an exported channel.
+func ReadFile(path string, secret *Secret) (*Data, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadData(f, secret)
+}
+
+// ReadFileFS reads and decodes an exported channel from a directory or bundle.
+func ReadFileFS(fsys fs.FS, name string, secret *Secret) (*Data, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadData(f, secret)
+}
+
+// writeCloser closes the writers in order: compression, encryption, file.
+type writeCloser struct {
+	io.Writer
+	closers []io.Closer
+}
+
+func (wc writeCloser) Close() error {
+	var firstErr error
+	for _, c := range wc.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Create creates the file for writing, readable only by the owner.
+// If secret is set, everything written is encrypted.
+func Create(path string, secret *Secret) (io.WriteCloser, error) {
+	return create(path, secret, false)
+}
+
+// CreateData creates the file for an exported channel, like Create,
+// but files named *.gz are also gzip-compressed.
+func CreateData(path string, secret *Secret) (io.WriteCloser, error) {
+	return create(path, secret, strings.HasSuffix(path, ".gz"))
+}
+
+func create(path string, secret *Secret, compress bool) (io.WriteCloser, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	wc := writeCloser{Writer: f, closers: []io.Closer{f}}
+
+	if secret != nil {
+		ew, err := secret.Encrypt(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		wc = writeCloser{Writer: ew, closers: append([]io.Closer{ew}, wc.closers...)}
+	}
+
+	if compress {
+		gw := gzip.NewWriter(wc.Writer)
+		wc = writeCloser{Writer: gw, closers: append([]io.Closer{gw}, wc.closers...)}
+	}
+
+	return wc, nil
+}
+
+// WriteFile writes content to the file, encrypting it if secret is set.
+func WriteFile(path string, content []byte, secret *Secret) error {
+	return write(path, content, secret, Create)
+}
+
+// WriteData writes an exported channel to the file,
+// compressing it if the name ends with .gz and encrypting it if secret is set.
+func WriteData(path string, content []byte, secret *Secret) error {
+	return write(path, content, secret, CreateData)
+}
+
+// CopyAsset copies the content opened by open to dst, like an attachment
+// into the directory of converted files, unless dst exists already.
+func CopyAsset(dst string, open func() (io.ReadCloser, error)) error {
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+
+	src, err := open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("could not create file: %w", err)
+	}
+
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		os.Remove(dst)
+		return fmt.Errorf("could not copy %q: %w", filepath.Base(dst), err)
+	}
+
+	return f.Close()
+}
+
+func write(
+	path string,
+	content []byte,
+	secret *Secret,
+	createFn func(string, *Secret) (io.WriteCloser, error),
+) error {
+	w, err := createFn(path, secret)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(content); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
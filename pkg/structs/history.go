@@ -0,0 +1,85 @@
+package structs
+
+import (
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// MessageHistory is what incremental exports and merged events noticed
+// about a message or reply after it was first exported.
+type MessageHistory struct {
+	// Versions are the earlier versions of an edited message, oldest first.
+	Versions []MessageVersion `json:"versions,omitempty"`
+	// Deleted is when the message was noticed to be deleted.
+	// Deleted messages are kept as they were last seen.
+	Deleted *time.Time `json:"deleted,omitempty"`
+}
+
+// MessageVersion is an earlier version of an edited message.
+type MessageVersion struct {
+	Text string `json:"text"`
+	// Edited is the timestamp of the edit that made this version, if any.
+	Edited string `json:"edited,omitempty"`
+	// Replaced is when the next version was noticed.
+	Replaced time.Time `json:"replaced"`
+}
+
+// HistoryOf returns the history of the message or reply, or nil.
+func (d *Data) HistoryOf(ts string) *MessageHistory {
+	return d.History[ts]
+}
+
+// AddVersion records the message as an earlier version,
+// noticed to be replaced at the time, unless it is recorded already.
+func (d *Data) AddVersion(msg slack.Msg, noticed time.Time) {
+	h := d.history(msg.Timestamp)
+
+	version := MessageVersion{Text: msg.Text, Edited: EditedTimestamp(msg), Replaced: noticed.UTC()}
+	for _, v := range h.Versions {
+		if v.Text == version.Text && v.Edited == version.Edited {
+			return
+		}
+	}
+
+	h.Versions = append(h.Versions, version)
+}
+
+// MarkDeleted records that the message was noticed to be deleted
+// at the time, keeping the time it was first noticed.
+func (d *Data) MarkDeleted(ts string, noticed time.Time) {
+	h := d.history(ts)
+	if h.Deleted == nil {
+		t := noticed.UTC()
+		h.Deleted = &t
+	}
+}
+
+func (d *Data) history(ts string) *MessageHistory {
+	if d.History == nil {
+		d.History = map[string]*MessageHistory{}
+	}
+
+	h, ok := d.History[ts]
+	if !ok {
+		h = &MessageHistory{}
+		d.History[ts] = h
+	}
+
+	return h
+}
+
+// EditedTimestamp returns the timestamp of the last edit
+// of the message, or an empty string if it wasn't edited.
+func EditedTimestamp(msg slack.Msg) string {
+	if msg.Edited == nil {
+		return ""
+	}
+
+	return msg.Edited.Timestamp
+}
+
+// IsEdit reports whether after is an edited version of before.
+func IsEdit(before, after slack.Msg) bool {
+	return before.Text != after.Text || EditedTimestamp(before) != EditedTimestamp(after)
+}
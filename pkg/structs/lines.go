@@ -0,0 +1,213 @@
+package structs
+
+import (
+	"cmp"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// LinesSchemaVersion is the version of the JSON Lines records below.
+// Fields are only ever added within a version; renaming or removing
+// a field, or changing its meaning, bumps the version.
+const LinesSchemaVersion = 1
+
+// LineMessage is a single message flattened into one JSON Lines record.
+// Thread replies are separate records pointing to their parent with ThreadTS.
+type LineMessage struct {
+	SchemaVersion int            `json:"schema_version"`
+	ChannelID     string         `json:"channel_id"`
+	ChannelName   string         `json:"channel_name"`
+	TS            string         `json:"ts"`
+	Time          time.Time      `json:"time"`
+	ThreadTS      string         `json:"thread_ts,omitempty"`
+	ReplyCount    int            `json:"reply_count"`
+	UserID        string         `json:"user_id"`
+	UserName      string         `json:"user_name"`
+	Subtype       string         `json:"subtype,omitempty"`
+	Text          string         `json:"text"`
+	Edited        bool           `json:"edited"`
+	Reactions     []LineReaction `json:"reactions"`
+	Files         []LineFile     `json:"files"`
+}
+
+// LineReaction is the number of reactions with one emoji.
+type LineReaction struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// LineFile references a file shared in a message.
+// Path is relative to the output directory and only set for downloaded files.
+type LineFile struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Title    string `json:"title"`
+	Mimetype string `json:"mimetype"`
+	Size     int    `json:"size"`
+	Path     string `json:"path,omitempty"`
+}
+
+// LineUser is a single user in users.jsonl.
+type LineUser struct {
+	SchemaVersion int    `json:"schema_version"`
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	RealName      string `json:"real_name"`
+	DisplayName   string `json:"display_name"`
+	Title         string `json:"title"`
+	TimeZone      string `json:"tz"`
+	IsBot         bool   `json:"is_bot"`
+	Deleted       bool   `json:"deleted"`
+}
+
+// LineChannel is a single channel in channels.jsonl.
+type LineChannel struct {
+	SchemaVersion int       `json:"schema_version"`
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Type          string    `json:"type"`
+	Topic         string    `json:"topic"`
+	Purpose       string    `json:"purpose"`
+	Created       time.Time `json:"created"`
+	IsArchived    bool      `json:"is_archived"`
+	Members       int       `json:"members"`
+	Messages      int       `json:"messages"`
+}
+
+// ChannelType returns the conversation type as used by conversations.list:
+// im, mpim, private_channel or public_channel.
+func ChannelType(channel *slack.Channel) string {
+	switch {
+	case channel.IsIM:
+		return "im"
+	case channel.IsMpIM:
+		return "mpim"
+	case channel.IsPrivate:
+		return "private_channel"
+	default:
+		return "public_channel"
+	}
+}
+
+// ChannelName returns the channel name,
+// or the name of the other user for direct messages.
+func ChannelName(channel slack.Channel, users map[string]*slack.User) string {
+	if channel.IsIM && channel.User != "" {
+		return "@" + lookupName(channel.User, users)
+	}
+
+	return cmp.Or(channel.Name, channel.ID)
+}
+
+// Lines flattens the messages of d, oldest first,
+// with every thread parent followed by its replies.
+// Dir is the directory of the channel relative to the output directory:
+// empty, or the workspace directory in Enterprise Grid exports.
+func Lines(d *Data, dir string) []LineMessage {
+	name := ChannelName(d.Channel, d.Users)
+
+	messages := make([]Message, len(d.Messages))
+	copy(messages, d.Messages)
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Timestamp < messages[j].Timestamp
+	})
+
+	lines := make([]LineMessage, 0, len(messages))
+	for _, m := range messages {
+		lines = append(lines, d.line(name, dir, m.Message, ""))
+
+		for _, reply := range m.Replies {
+			// conversations.replies includes the parent
+			if reply.Timestamp == m.Timestamp {
+				continue
+			}
+			lines = append(lines, d.line(name, dir, reply, m.Timestamp))
+		}
+	}
+
+	return lines
+}
+
+func (d *Data) line(channelName, dir string, m slack.Message, threadTS string) LineMessage {
+	t, _ := ParseTimestamp(m.Timestamp)
+
+	line := LineMessage{
+		SchemaVersion: LinesSchemaVersion,
+		ChannelID:     d.Channel.ID,
+		ChannelName:   channelName,
+		TS:            m.Timestamp,
+		Time:          t,
+		ThreadTS:      threadTS,
+		ReplyCount:    m.ReplyCount,
+		UserID:        m.User,
+		UserName:      cmp.Or(m.Username, m.BotID),
+		Subtype:       m.SubType,
+		Text:          PlainText(m, d.Users),
+		Edited:        m.Edited != nil,
+		Reactions:     make([]LineReaction, 0, len(m.Reactions)),
+		Files:         make([]LineFile, 0, len(m.Files)),
+	}
+
+	if m.User != "" {
+		line.UserName = lookupName(m.User, d.Users)
+	}
+
+	for _, r := range m.Reactions {
+		line.Reactions = append(line.Reactions, LineReaction{Name: r.Name, Count: r.Count})
+	}
+
+	for _, f := range m.Files {
+		lf := LineFile{ID: f.ID, Name: f.Name, Title: f.Title, Mimetype: f.Mimetype, Size: f.Size}
+		if filename, ok := d.Files[f.ID]; ok {
+			lf.Path = path.Join(dir, d.Channel.ID, f.ID+"-"+filename)
+		}
+		line.Files = append(line.Files, lf)
+	}
+
+	return line
+}
+
+// LineUsers returns the users sorted by ID.
+func LineUsers(users map[string]*slack.User) []LineUser {
+	result := make([]LineUser, 0, len(users))
+	for id, user := range users {
+		if user == nil {
+			continue
+		}
+
+		result = append(result, LineUser{
+			SchemaVersion: LinesSchemaVersion,
+			ID:            cmp.Or(user.ID, id),
+			Name:          user.Name,
+			RealName:      cmp.Or(user.Profile.RealNameNormalized, user.RealName),
+			DisplayName:   user.Profile.DisplayNameNormalized,
+			Title:         user.Profile.Title,
+			TimeZone:      user.TZ,
+			IsBot:         user.IsBot,
+			Deleted:       user.Deleted,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+
+	return result
+}
+
+// LineChannelOf describes the channel of d.
+func LineChannelOf(d *Data) LineChannel {
+	return LineChannel{
+		SchemaVersion: LinesSchemaVersion,
+		ID:            d.Channel.ID,
+		Name:          ChannelName(d.Channel, d.Users),
+		Type:          ChannelType(&d.Channel),
+		Topic:         d.Channel.Topic.Value,
+		Purpose:       d.Channel.Purpose.Value,
+		Created:       d.Channel.Created.Time().UTC(),
+		IsArchived:    d.Channel.IsArchived,
+		Members:       d.Channel.NumMembers,
+		Messages:      len(d.Messages),
+	}
+}
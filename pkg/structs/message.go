@@ -3,14 +3,17 @@
 package structs
 
 import (
+	"cmp"
 	"errors"
-	"log"
+	"log/slog"
 	"math"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/slack-go/slack"
+
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
 )
 
 const sameContextDuration = 15 * time.Minute
@@ -30,13 +33,13 @@ func (m *Message) SameContext(m2 Message) bool {
 
 	mSec, err := extractUnixTimestamp(m.Timestamp)
 	if err != nil {
-		log.Printf("could not extract timestamp from %q: %v", m.Timestamp, err)
+		slog.Warn("Could not extract timestamp", "ts", m.Timestamp, logging.Err(err))
 		return false
 	}
 
 	m2Sec, err := extractUnixTimestamp(m2.Timestamp)
 	if err != nil {
-		log.Printf("could not extract timestamp from %q: %v", m2.Timestamp, err)
+		slog.Warn("Could not extract timestamp", "ts", m2.Timestamp, logging.Err(err))
 		return false
 	}
 
@@ -59,8 +62,39 @@ func extractUnixTimestamp(ts string) (int64, error) {
 
 // Data struct used to marshal/unmarshal JSON data.
 type Data struct {
-	Channel  slack.Channel          `json:"channel"`
-	Messages []Message              `json:"messages"`
-	Users    map[string]*slack.User `json:"users"`
-	Files    map[string]string      `json:"files"`
+	SchemaVersion int                    `json:"schema_version"`
+	Channel       slack.Channel          `json:"channel"`
+	Messages      []Message              `json:"messages"`
+	Users         map[string]*slack.User `json:"users"`
+	Files         map[string]string      `json:"files"`
+	Teams         map[string]*Team       `json:"teams,omitempty"`
+
+	// History of edited and deleted messages and replies by timestamp,
+	// kept by incremental exports and merged events.
+	History map[string]*MessageHistory `json:"history,omitempty"`
+}
+
+// Team is a workspace or organization the users of a channel belong to,
+// by user.TeamID. External teams are other organizations,
+// like in Slack Connect channels.
+type Team struct {
+	ID       string `json:"id"`
+	Name     string `json:"name,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	External bool   `json:"external,omitempty"`
+}
+
+// ExternalTeam returns the name of the external organization the user
+// belongs to, or an empty string for internal and unknown users.
+func (d *Data) ExternalTeam(user *slack.User) string {
+	if user == nil {
+		return ""
+	}
+
+	team, ok := d.Teams[user.TeamID]
+	if !ok || !team.External {
+		return ""
+	}
+
+	return cmp.Or(team.Name, team.Domain, team.ID)
 }
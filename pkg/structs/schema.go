@@ -0,0 +1,88 @@
+package structs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SchemaVersion is the version of Data written by the exporter.
+// Bump it whenever the shape of Data changes, and register a migration
+// from the previous version, so that older exports keep loading.
+const SchemaVersion = 4
+
+var errNewerSchema = errors.New("file was written by a newer version, update to read it")
+
+// Migration upgrades a document by one schema version, in place.
+// It works on the raw JSON, as older documents may not fit Data anymore.
+type Migration func(doc map[string]json.RawMessage) error
+
+// migrations are keyed by the version they upgrade from.
+// The migrations registered so far only bump the version: the fields
+// added since are optional, and documents without them load as they are.
+var migrations = map[int]Migration{
+	// version 1 is every export written before schema_version existed
+	1: func(map[string]json.RawMessage) error { return nil },
+	// version 3 added the optional teams users belong to
+	2: func(map[string]json.RawMessage) error { return nil },
+	// version 4 added the optional history of edited and deleted messages
+	3: func(map[string]json.RawMessage) error { return nil },
+}
+
+// DecodeData decodes an exported channel, upgrading it to SchemaVersion
+// if it is older. It also returns the version the document had.
+func DecodeData(content []byte) (*Data, int, error) {
+	var header struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(content, &header); err != nil {
+		return nil, 0, fmt.Errorf("could not unmarshal data: %w", err)
+	}
+
+	version := header.SchemaVersion
+	if version == 0 {
+		version = 1
+	}
+
+	if version > SchemaVersion {
+		return nil, version, fmt.Errorf("%w: schema version %d, supported %d", errNewerSchema, version, SchemaVersion)
+	}
+
+	if version < SchemaVersion {
+		var err error
+		content, err = migrate(content, version)
+		if err != nil {
+			return nil, version, err
+		}
+	}
+
+	var data Data
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, version, fmt.Errorf("could not unmarshal data: %w", err)
+	}
+
+	return &data, version, nil
+}
+
+// migrate applies the migrations from version up to SchemaVersion.
+func migrate(content []byte, version int) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("could not unmarshal data: %w", err)
+	}
+
+	for v := version; v < SchemaVersion; v++ {
+		m, ok := migrations[v]
+		if !ok {
+			return nil, fmt.Errorf("no migration from schema version %d", v)
+		}
+
+		if err := m(doc); err != nil {
+			return nil, fmt.Errorf("could not migrate from schema version %d: %w", v, err)
+		}
+
+		doc["schema_version"] = json.RawMessage(fmt.Sprint(v + 1))
+	}
+
+	return json.Marshal(doc)
+}
@@ -0,0 +1,50 @@
+package structs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDecodeDataUpgrade(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		version int
+	}{
+		{"unversioned", `{"channel":{"id":"C1"},"messages":[{"ts":"1700000000.000100","text":"hello"}]}`, 1},
+		{"version 2", `{"schema_version":2,"channel":{"id":"C1"},"messages":[{"ts":"1700000000.000100","text":"hello"}]}`, 2},
+		{"current", fmt.Sprintf(`{"schema_version":%d,"channel":{"id":"C1"},"messages":[{"ts":"1700000000.000100","text":"hello"}]}`, SchemaVersion), SchemaVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, version, err := DecodeData([]byte(tt.content))
+			if err != nil {
+				t.Fatalf("could not decode: %v", err)
+			}
+
+			if version != tt.version {
+				t.Errorf("version = %d, want %d", version, tt.version)
+			}
+			if data.SchemaVersion != SchemaVersion {
+				t.Errorf("upgraded to schema version %d, want %d", data.SchemaVersion, SchemaVersion)
+			}
+			if data.Channel.ID != "C1" || len(data.Messages) != 1 || data.Messages[0].Text != "hello" {
+				t.Errorf("data changed while upgrading: %+v", data)
+			}
+		})
+	}
+}
+
+func TestDecodeDataNewer(t *testing.T) {
+	content := fmt.Sprintf(`{"schema_version":%d,"channel":{"id":"C1"}}`, SchemaVersion+1)
+
+	_, version, err := DecodeData([]byte(content))
+	if !errors.Is(err, errNewerSchema) {
+		t.Fatalf("got %v, want %v", err, errNewerSchema)
+	}
+	if version != SchemaVersion+1 {
+		t.Errorf("version = %d, want %d", version, SchemaVersion+1)
+	}
+}
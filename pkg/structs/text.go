@@ -0,0 +1,205 @@
+package structs
+
+import (
+	"cmp"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// mrkdwnLink matches the <...> sequences of Slack's mrkdwn:
+// user, channel and group mentions, special mentions and links.
+var mrkdwnLink = regexp.MustCompile(`<([^<>|]*)(?:\|([^<>]*))?>`)
+
+// UserName returns the name to show for the user,
+// preferring the real name over the display name and the handle.
+func UserName(user *slack.User) string {
+	if user == nil {
+		return "unknown"
+	}
+
+	for _, name := range []string{
+		user.Profile.RealNameNormalized,
+		user.RealName,
+		user.Profile.DisplayNameNormalized,
+		user.Name,
+	} {
+		if name != "" {
+			return name
+		}
+	}
+
+	return user.ID
+}
+
+// ParseTimestamp converts a Slack timestamp like "1700000000.000100" to time.
+func ParseTimestamp(ts string) (time.Time, error) {
+	sec, frac, _ := strings.Cut(ts, ".")
+
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var usec int64
+	if frac != "" {
+		// Slack timestamps have microsecond precision
+		frac = (frac + "000000")[:6]
+		if usec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return time.Unix(s, usec*int64(time.Microsecond)).UTC(), nil
+}
+
+// Resolver resolves mentions to names when converting messages to plain text.
+// Mentions it can't resolve are kept as IDs.
+type Resolver struct {
+	Users map[string]*slack.User
+	// Channels maps channel IDs to names, without the leading "#".
+	Channels map[string]string
+}
+
+// PlainText returns the text of the message without formatting,
+// with user mentions resolved to names through users.
+// Rich text blocks are preferred, as the text field may be a fallback.
+func PlainText(m slack.Message, users map[string]*slack.User) string {
+	return Resolver{Users: users}.PlainText(m)
+}
+
+// MrkdwnToPlainText strips Slack's mrkdwn markup from text:
+// mentions are replaced with names, links with their labels.
+func MrkdwnToPlainText(text string, users map[string]*slack.User) string {
+	return Resolver{Users: users}.MrkdwnToPlainText(text)
+}
+
+// PlainText is PlainText with both user and channel mentions resolved.
+func (r Resolver) PlainText(m slack.Message) string {
+	sb := &strings.Builder{}
+	for _, block := range m.Blocks.BlockSet {
+		if b, ok := block.(*slack.RichTextBlock); ok {
+			r.writeRichTextElements(sb, b.Elements)
+		}
+	}
+
+	if sb.Len() > 0 {
+		return strings.TrimRight(sb.String(), "\n")
+	}
+
+	return r.MrkdwnToPlainText(m.Text)
+}
+
+// MrkdwnToPlainText is MrkdwnToPlainText with both user and channel mentions resolved.
+func (r Resolver) MrkdwnToPlainText(text string) string {
+	text = mrkdwnLink.ReplaceAllStringFunc(text, func(s string) string {
+		match := mrkdwnLink.FindStringSubmatch(s)
+		target, label := match[1], match[2]
+
+		switch {
+		case strings.HasPrefix(target, "@"):
+			if label != "" {
+				return "@" + label
+			}
+			return "@" + r.userName(target[1:])
+		case strings.HasPrefix(target, "#"):
+			if label != "" {
+				return "#" + label
+			}
+			return "#" + r.channelName(target[1:])
+		case strings.HasPrefix(target, "!subteam^"):
+			return label
+		case strings.HasPrefix(target, "!"):
+			// special mentions like <!here> or <!date^...|fallback>
+			if label != "" {
+				return label
+			}
+			return "@" + strings.TrimPrefix(target, "!")
+		case label != "":
+			return label
+		default:
+			return target
+		}
+	})
+
+	return html.UnescapeString(text)
+}
+
+func (r Resolver) userName(id string) string {
+	return lookupName(id, r.Users)
+}
+
+func lookupName(id string, users map[string]*slack.User) string {
+	if user, ok := users[id]; ok {
+		return UserName(user)
+	}
+
+	return id
+}
+
+func (r Resolver) channelName(id string) string {
+	if name, ok := r.Channels[id]; ok {
+		return name
+	}
+
+	return id
+}
+
+func (r Resolver) writeRichTextElements(sb *strings.Builder, elements []slack.RichTextElement) {
+	for _, element := range elements {
+		// every element starts on a new line
+		if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteString("\n")
+		}
+
+		switch e := element.(type) {
+		case *slack.RichTextSection:
+			r.writeRichTextSectionElements(sb, e.Elements)
+		case *slack.RichTextQuote:
+			sb.WriteString("> ")
+			r.writeRichTextSectionElements(sb, e.Elements)
+		case *slack.RichTextPreformatted:
+			r.writeRichTextSectionElements(sb, e.Elements)
+		case *slack.RichTextList:
+			for i, item := range e.Elements {
+				if i > 0 {
+					sb.WriteString("\n")
+				}
+				sb.WriteString(strings.Repeat("  ", e.Indent))
+				if e.Style == slack.RTEListOrdered {
+					sb.WriteString(strconv.Itoa(i+1) + ". ")
+				} else {
+					sb.WriteString("- ")
+				}
+				if section, ok := item.(*slack.RichTextSection); ok {
+					r.writeRichTextSectionElements(sb, section.Elements)
+				}
+			}
+		}
+	}
+}
+
+func (r Resolver) writeRichTextSectionElements(sb *strings.Builder, elements []slack.RichTextSectionElement) {
+	for _, element := range elements {
+		switch e := element.(type) {
+		case *slack.RichTextSectionTextElement:
+			sb.WriteString(e.Text)
+		case *slack.RichTextSectionUserElement:
+			sb.WriteString("@" + r.userName(e.UserID))
+		case *slack.RichTextSectionChannelElement:
+			sb.WriteString("#" + r.channelName(e.ChannelID))
+		case *slack.RichTextSectionUserGroupElement:
+			sb.WriteString("@" + e.UsergroupID)
+		case *slack.RichTextSectionBroadcastElement:
+			sb.WriteString("@" + e.Range)
+		case *slack.RichTextSectionEmojiElement:
+			sb.WriteString(":" + e.Name + ":")
+		case *slack.RichTextSectionLinkElement:
+			sb.WriteString(cmp.Or(e.Text, e.URL))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package structs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+)
+
+// WorkspacesName is the name of the workspace index of an Enterprise Grid
+// export, written next to one subdirectory per workspace.
+const WorkspacesName = "workspaces.json"
+
+// Workspaces lists the workspaces of an Enterprise Grid export.
+// Each workspace is exported into a subdirectory named after its ID,
+// which is laid out like a single workspace export.
+type Workspaces struct {
+	Workspaces []Workspace `json:"workspaces"`
+}
+
+// Workspace describes a single exported workspace.
+// Channels shared between workspaces are listed, and exported, only once.
+type Workspace struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Domain   string   `json:"domain,omitempty"`
+	Channels []string `json:"channels"`
+}
+
+// ReadWorkspaces reads the workspace index at the root of an export.
+// It returns nil if the export is not an Enterprise Grid export.
+func ReadWorkspaces(fsys fs.FS) (*Workspaces, error) {
+	content, err := fs.ReadFile(fsys, WorkspacesName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read workspaces: %w", err)
+	}
+
+	var w Workspaces
+	if err := json.Unmarshal(content, &w); err != nil {
+		return nil, fmt.Errorf("could not unmarshal workspaces: %w", err)
+	}
+
+	return &w, nil
+}
+
+// WorkspaceOf returns the ID of the workspace the channel was exported in,
+// or an empty string if it is not listed.
+func (w *Workspaces) WorkspaceOf(channelID string) string {
+	if w == nil {
+		return ""
+	}
+
+	for _, workspace := range w.Workspaces {
+		for _, id := range workspace.Channels {
+			if id == channelID {
+				return workspace.ID
+			}
+		}
+	}
+
+	return ""
+}
+
+// Find returns the index of the workspace with the ID, or -1.
+func (w *Workspaces) Find(teamID string) int {
+	for i, workspace := range w.Workspaces {
+		if workspace.ID == teamID {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Sort orders the workspaces by ID and their channels by ID,
+// so that the index doesn't change between identical exports.
+func (w *Workspaces) Sort() {
+	sort.Slice(w.Workspaces, func(i, j int) bool {
+		return w.Workspaces[i].ID < w.Workspaces[j].ID
+	})
+
+	for _, workspace := range w.Workspaces {
+		sort.Strings(workspace.Channels)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chuhlomin/slack-exporter/pkg/exporter"
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+	"github.com/chuhlomin/slack-exporter/pkg/structs"
+)
+
+// planEntry describes what the export will do with a single channel.
+type planEntry struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Workspace string `json:"workspace,omitempty"`
+	Type      string `json:"type"`
+	Members   int    `json:"members"`
+	Export    bool   `json:"export"`
+	Skip      string `json:"skip_reason,omitempty"`
+	Note      string `json:"note,omitempty"`
+	APICalls  int    `json:"api_calls"`
+}
+
+// plan is the result of a dry run.
+// API calls and duration are lower bounds: the number of history pages,
+// threads and files is not known without fetching the history.
+type plan struct {
+	Channels        []planEntry `json:"channels"`
+	Export          int         `json:"export"`
+	Skipped         int         `json:"skipped"`
+	UserLookups     int         `json:"user_lookups"`
+	APICalls        int         `json:"api_calls"`
+	EstimatedTime   string      `json:"estimated_time"`
+	EstimatedTimeMs int64       `json:"estimated_time_ms"`
+}
+
+// makePlan inspects the channel metadata, without fetching any history,
+// and estimates the API calls needed to export the targets.
+func makePlan(e *exporter.Exporter, targets []exporter.Target) *plan {
+	p := &plan{Channels: make([]planEntry, 0, len(targets))}
+	users := map[string]struct{}{}
+	maxMembers := 0
+
+	for _, t := range targets {
+		channel := t.Info
+		if channel == nil {
+			var err error
+			channel, err = e.ChannelInfo(t.ID)
+			if err != nil {
+				slog.Debug("Could not get channel info", logging.KeyChannel, t.ID, logging.Err(err))
+				p.Channels = append(p.Channels, planEntry{
+					ID:        t.ID,
+					Name:      t.Name,
+					Workspace: t.Workspace,
+					Skip:      "no access: " + err.Error(),
+				})
+				p.Skipped++
+				continue
+			}
+		}
+
+		entry := planEntry{
+			ID:        channel.ID,
+			Name:      cmp.Or(channel.Name, channel.User, channel.ID),
+			Workspace: t.Workspace,
+			Type:      structs.ChannelType(channel),
+			Members:   channel.NumMembers,
+		}
+
+		switch {
+		case channel.IsArchived && !cfg.IncludeArchived:
+			entry.Skip = "archived"
+			// conversations.info is still called to find that out
+			entry.APICalls = 1
+		default:
+			entry.Export = true
+			// conversations.info and at least one conversations.history page
+			entry.APICalls = 2
+		}
+
+		if !channel.IsMember && !channel.IsIM && !channel.IsMpIM {
+			entry.Note = "not a member"
+		}
+
+		if entry.Export {
+			p.Export++
+			if channel.User != "" {
+				users[channel.User] = struct{}{}
+			}
+			maxMembers = max(maxMembers, channel.NumMembers)
+		} else {
+			p.Skipped++
+		}
+
+		p.APICalls += entry.APICalls
+		p.Channels = append(p.Channels, entry)
+	}
+
+	// every user who posted is looked up once, the members of the
+	// largest channel is the best guess without fetching the history
+	p.UserLookups = max(len(users), maxMembers)
+	p.APICalls += p.UserLookups
+
+	estimated := time.Duration(p.APICalls) * time.Minute / exporter.RequestsPerMinute
+	p.EstimatedTime = estimated.Round(time.Second).String()
+	p.EstimatedTimeMs = estimated.Milliseconds()
+
+	return p
+}
+
+func (p *plan) write(w io.Writer, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p)
+	}
+
+	// the workspace is only shown when channels of several are planned
+	workspaces := map[string]struct{}{}
+	for _, entry := range p.Channels {
+		workspaces[entry.Workspace] = struct{}{}
+	}
+	showWorkspace := len(workspaces) > 1
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if showWorkspace {
+		fmt.Fprint(tw, "WORKSPACE\t")
+	}
+	fmt.Fprintln(tw, "ID\tNAME\tTYPE\tMEMBERS\tACTION\tAPI CALLS\tNOTE")
+
+	for _, entry := range p.Channels {
+		action := "export"
+		if !entry.Export {
+			action = "skip: " + entry.Skip
+		}
+
+		if showWorkspace {
+			fmt.Fprintf(tw, "%s\t", entry.Workspace)
+		}
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%d\t%s\t%d\t%s\n",
+			entry.ID,
+			entry.Name,
+			entry.Type,
+			entry.Members,
+			action,
+			entry.APICalls,
+			entry.Note,
+		)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("could not write plan: %w", err)
+	}
+
+	fmt.Fprintf(w, "\nChannels to export: %d, skipped: %d\n", p.Export, p.Skipped)
+	fmt.Fprintf(w, "User lookups:       ~%d\n", p.UserLookups)
+	fmt.Fprintf(w, "API calls:          at least %d\n", p.APICalls)
+	fmt.Fprintf(w, "Estimated time:     at least %s (%d requests per minute)\n", p.EstimatedTime, exporter.RequestsPerMinute)
+	fmt.Fprintln(w, "Each thread adds one conversations.replies call and each file one download.")
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/progress"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/chuhlomin/slack-exporter/pkg/exporter"
+	"github.com/chuhlomin/slack-exporter/pkg/logging"
+)
+
+const logPaneLines = 8
+
+// Messages of the progress view, besides the exporter events.
+type (
+	progressLogMsg  string
+	progressDoneMsg struct{ err error }
+	progressTickMsg time.Time
+)
+
+type modelProgress struct {
+	bar     progress.Model
+	started time.Time
+	now     time.Time
+
+	index    int
+	total    int
+	finished int
+	channel  string
+
+	messages     int
+	threads      int
+	threadsTotal int
+	files        int
+	bytes        int64
+	rateLimits   int
+	rateLimitFor time.Duration
+
+	logs []string
+	done bool
+	err  error
+}
+
+func initialModelProgress() *modelProgress {
+	return &modelProgress{
+		bar:     progress.New(progress.WithScaledGradient("#FF7CCB", "#FDFF8C")),
+		started: time.Now(),
+		now:     time.Now(),
+	}
+}
+
+func progressTick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
+		return progressTickMsg(t)
+	})
+}
+
+func (mp *modelProgress) Init() tea.Cmd {
+	return tea.Batch(tea.SetWindowTitle("Exporting Slack messages"), progressTick())
+}
+
+func (mp *modelProgress) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "esc":
+			mp.err = errInterrupted
+			return mp, tea.Quit
+		}
+	case tea.WindowSizeMsg:
+		mp.bar.Width = min(max(msg.Width-20, 10), 80)
+	case progressTickMsg:
+		mp.now = time.Time(msg)
+		return mp, progressTick()
+	case exporter.ChannelStarted:
+		mp.index, mp.total, mp.channel = msg.Index, msg.Total, msg.Name
+		mp.messages, mp.threads, mp.threadsTotal = 0, 0, 0
+	case exporter.ChannelDone:
+		mp.finished++
+	case exporter.MessagesFetched:
+		mp.messages = msg.Count
+	case exporter.ThreadFetched:
+		mp.threads, mp.threadsTotal = msg.Done, msg.Total
+	case exporter.FileDownloaded:
+		mp.files++
+		mp.bytes += msg.Bytes
+	case exporter.RateLimited:
+		mp.rateLimits++
+		mp.rateLimitFor += msg.Wait
+	case progressLogMsg:
+		mp.logs = append(mp.logs, string(msg))
+		if len(mp.logs) > logPaneLines {
+			mp.logs = mp.logs[len(mp.logs)-logPaneLines:]
+		}
+	case progressDoneMsg:
+		mp.done = true
+		mp.err = msg.err
+		return mp, tea.Quit
+	}
+
+	return mp, nil
+}
+
+// eta estimates the remaining time from the average time per finished channel.
+func (mp *modelProgress) eta() string {
+	if mp.finished == 0 || mp.total == 0 {
+		return "estimating…"
+	}
+
+	elapsed := mp.now.Sub(mp.started)
+	remaining := elapsed / time.Duration(mp.finished) * time.Duration(mp.total-mp.finished)
+
+	return remaining.Round(time.Second).String()
+}
+
+func (mp *modelProgress) View() string {
+	var b strings.Builder
+
+	percent := 0.0
+	if mp.total > 0 {
+		percent = float64(mp.finished) / float64(mp.total)
+	}
+
+	fmt.Fprintf(&b, "%s (%d/%d) %s\n\n", mp.bar.ViewAs(percent), mp.index, mp.total, mp.channel)
+	fmt.Fprintf(&b, "Messages:    %d\n", mp.messages)
+	fmt.Fprintf(&b, "Threads:     %d/%d\n", mp.threads, mp.threadsTotal)
+	fmt.Fprintf(&b, "Files:       %d (%s)\n", mp.files, formatBytes(mp.bytes))
+	fmt.Fprintf(&b, "Rate limits: %d waits (%s)\n", mp.rateLimits, mp.rateLimitFor.Round(time.Second))
+	fmt.Fprintf(&b, "Elapsed:     %s, ETA %s\n\n", mp.now.Sub(mp.started).Round(time.Second), mp.eta())
+
+	for _, line := range mp.logs {
+		b.WriteString(blurredStyle.Render(line) + "\n")
+	}
+
+	if !mp.done {
+		b.WriteString("\nPress Ctrl+C to stop.\n")
+	}
+
+	return b.String()
+}
+
+// logPane forwards log output into the progress view.
+type logPane struct {
+	send func(tea.Msg)
+}
+
+func (lp logPane) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		lp.send(progressLogMsg(line))
+	}
+
+	return len(p), nil
+}
+
+// plainProgress reports progress with log lines,
+// used when the output is not a terminal (cron, CI).
+type plainProgress struct {
+	id         string
+	channel    string
+	messages   int
+	threads    int
+	files      int
+	bytes      int64
+	rateLimits time.Duration
+}
+
+func (pp *plainProgress) send(msg tea.Msg) {
+	switch msg := msg.(type) {
+	case exporter.ChannelStarted:
+		*pp = plainProgress{id: msg.ID, channel: msg.Name}
+		slog.Info(
+			"Exporting channel",
+			logging.KeyChannel, msg.ID,
+			"name", msg.Name,
+			"index", msg.Index,
+			"total", msg.Total,
+		)
+	case exporter.MessagesFetched:
+		pp.messages = msg.Count
+	case exporter.ThreadFetched:
+		pp.threads = msg.Done
+	case exporter.FileDownloaded:
+		pp.files++
+		pp.bytes += msg.Bytes
+	case exporter.RateLimited:
+		pp.rateLimits += msg.Wait
+	case exporter.ChannelDone:
+		slog.Info(
+			"Exported channel",
+			logging.KeyChannel, pp.id,
+			"name", pp.channel,
+			"messages", pp.messages,
+			"threads", pp.threads,
+			"files", pp.files,
+			"bytes", pp.bytes,
+			"rate_limit_wait", pp.rateLimits.Round(time.Second),
+		)
+	}
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}